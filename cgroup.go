@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cgroupLimits are the per-runner resource limits applied through cgroup v2
+type cgroupLimits struct {
+	CPUWeight int
+	CPUQuota  int
+	MemoryMax ByteSize
+	PidsMax   int
+}
+
+func (l cgroupLimits) enabled() bool {
+	return l.CPUWeight > 0 || l.CPUQuota > 0 || l.MemoryMax > 0 || l.PidsMax > 0
+}
+
+// files returns the cgroup interface files to write for the limits
+func (l cgroupLimits) files() map[string]string {
+	files := make(map[string]string)
+	if l.CPUWeight > 0 {
+		files["cpu.weight"] = fmt.Sprintf("%d", l.CPUWeight)
+	}
+	if l.CPUQuota > 0 {
+		// Quota is a percentage of one CPU over the default 100ms period
+		files["cpu.max"] = fmt.Sprintf("%d 100000", l.CPUQuota*1000)
+	}
+	if l.MemoryMax > 0 {
+		files["memory.max"] = fmt.Sprintf("%d", int64(l.MemoryMax))
+	}
+	if l.PidsMax > 0 {
+		files["pids.max"] = fmt.Sprintf("%d", l.PidsMax)
+	}
+	return files
+}
+
+func (l cgroupLimits) String() string {
+	var parts []string
+	if l.CPUWeight > 0 {
+		parts = append(parts, fmt.Sprintf("cpu weight %d", l.CPUWeight))
+	}
+	if l.CPUQuota > 0 {
+		parts = append(parts, fmt.Sprintf("cpu quota %d%%", l.CPUQuota))
+	}
+	if l.MemoryMax > 0 {
+		parts = append(parts, fmt.Sprintf("memory max %s", l.MemoryMax.Human()))
+	}
+	if l.PidsMax > 0 {
+		parts = append(parts, fmt.Sprintf("pids max %d", l.PidsMax))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s *StartCommand) cgroupLimits() cgroupLimits {
+	return cgroupLimits{
+		CPUWeight: s.CPUWeight,
+		CPUQuota:  s.CPUQuota,
+		MemoryMax: s.MemoryMax,
+		PidsMax:   s.PidsMax,
+	}
+}
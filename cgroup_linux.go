@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// cgroupRoot is where the unified cgroup v2 hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupManager creates one child cgroup per runner below the cgroup ghrunner
+// was started in. Under systemd that requires Delegate=yes on the unit.
+type cgroupManager struct {
+	base   string
+	limits cgroupLimits
+}
+
+// runnerCgroup is the cgroup a runner process group is started in
+type runnerCgroup struct {
+	path     string
+	oomKills int
+}
+
+// setupCgroups moves ghrunner into a leaf cgroup of its own so controllers
+// can be enabled for the sibling runner cgroups
+func setupCgroups(limits cgroupLimits) (*cgroupManager, error) {
+	base, err := ownCgroup()
+	if err != nil {
+		return nil, err
+	}
+
+	supervisorDir := filepath.Join(base, "supervisor")
+	if err := os.MkdirAll(supervisorDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s (is the cgroup delegated?): %w", supervisorDir, err)
+	}
+	if err := os.WriteFile(filepath.Join(supervisorDir, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		return nil, fmt.Errorf("failed to move ghrunner into %s: %w", supervisorDir, err)
+	}
+
+	var controllers []string
+	files := limits.files()
+	for _, c := range []string{"cpu", "memory", "pids"} {
+		for name := range files {
+			if strings.HasPrefix(name, c+".") {
+				controllers = append(controllers, "+"+c)
+				break
+			}
+		}
+	}
+	subtree := filepath.Join(base, "cgroup.subtree_control")
+	if err := os.WriteFile(subtree, []byte(strings.Join(controllers, " ")), 0644); err != nil {
+		return nil, fmt.Errorf("failed to enable controllers %s in %s: %w", strings.Join(controllers, " "), base, err)
+	}
+
+	return &cgroupManager{base: base, limits: limits}, nil
+}
+
+// ownCgroup returns the cgroup v2 directory of the current process
+func ownCgroup() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if rel, ok := strings.CutPrefix(line, "0::"); ok {
+			dir := filepath.Join(cgroupRoot, rel)
+			if _, err := os.Stat(filepath.Join(dir, "cgroup.controllers")); err != nil {
+				return "", fmt.Errorf("cgroup v2 is not mounted at %s", cgroupRoot)
+			}
+			// Already moved by a previous setup in this process
+			if filepath.Base(dir) == "supervisor" {
+				dir = filepath.Dir(dir)
+			}
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("cgroup v2 is not available")
+}
+
+// forRunner creates or reuses the cgroup of a runner and applies the limits
+func (m *cgroupManager) forRunner(dir string) (*runnerCgroup, error) {
+	name := "runner-" + filepath.Base(filepath.Dir(dir)) + "_" + filepath.Base(dir)
+	path := filepath.Join(m.base, name)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	files := m.limits.files()
+	names := make([]string, 0, len(files))
+	for file := range files {
+		names = append(names, file)
+	}
+	sort.Strings(names)
+	for _, file := range names {
+		if err := os.WriteFile(filepath.Join(path, file), []byte(files[file]), 0644); err != nil {
+			return nil, fmt.Errorf("failed to set %s: %w", file, err)
+		}
+	}
+
+	cg := &runnerCgroup{path: path}
+	cg.oomKills = cg.readOOMKills()
+	return cg, nil
+}
+
+// apply makes cmd start directly inside the cgroup. The returned function
+// must be called once the process has started.
+func (c *runnerCgroup) apply(cmd *exec.Cmd) (func(), error) {
+	fd, err := syscall.Open(c.path, syscall.O_DIRECTORY|syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", c.path, err)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = fd
+	return func() { syscall.Close(fd) }, nil
+}
+
+// oomKilled reports whether the OOM killer fired in the cgroup since the last call
+func (c *runnerCgroup) oomKilled() bool {
+	kills := c.readOOMKills()
+	killed := kills > c.oomKills
+	c.oomKills = kills
+	return killed
+}
+
+func (c *runnerCgroup) readOOMKills() int {
+	file, err := os.Open(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+			n, _ := strconv.Atoi(value)
+			return n
+		}
+	}
+	return 0
+}
+
+// remove deletes the cgroup once no process is left in it
+func (c *runnerCgroup) remove() {
+	syscall.Rmdir(c.path)
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+type cgroupManager struct{}
+
+type runnerCgroup struct{}
+
+func setupCgroups(limits cgroupLimits) (*cgroupManager, error) {
+	return nil, fmt.Errorf("cgroup resource limits are not supported on %s", runtime.GOOS)
+}
+
+func (m *cgroupManager) forRunner(dir string) (*runnerCgroup, error) {
+	return &runnerCgroup{}, nil
+}
+
+func (c *runnerCgroup) apply(cmd *exec.Cmd) (func(), error) {
+	return func() {}, nil
+}
+
+func (c *runnerCgroup) oomKilled() bool {
+	return false
+}
+
+func (c *runnerCgroup) remove() {}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// driftReport collects the differences between the installed services and
+// what enable would install
+type driftReport struct {
+	drifted int
+}
+
+func (r *driftReport) add(format string, args ...any) {
+	r.drifted++
+	fmt.Printf("Drift: "+format+"\n", args...)
+}
+
+// file compares an installed file with its expected content, printing a
+// unified diff when they differ
+func (r *driftReport) file(path, expected string) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.add("%s is not installed", path)
+		} else {
+			r.add("failed to read %s: %v", path, err)
+		}
+		return
+	}
+	if string(content) == expected {
+		fmt.Printf("OK: %s\n", path)
+		return
+	}
+	r.add("%s differs from what enable would install", path)
+	fmt.Print(unifiedDiff(path+" (installed)", path+" (expected)", string(content), expected))
+}
+
+// leftover reports a service file of the other systemd layout
+func (r *driftReport) leftover(path string) {
+	if _, err := os.Stat(path); err == nil {
+		r.add("%s is installed but not used by --layout, runners may run twice", path)
+	}
+}
+
+// userExists reports a missing Linux user
+func (r *driftReport) userExists(username string) bool {
+	if _, err := user.Lookup(username); err != nil {
+		r.add("user %s does not exist", username)
+		return false
+	}
+	return true
+}
+
+// owner reports a path not owned by username
+func (r *driftReport) owner(path, username string) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		r.add("failed to stat %s: %v", path, err)
+		return
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	uid := strconv.FormatUint(uint64(st.Uid), 10)
+	if uid == u.Uid {
+		return
+	}
+	current := uid
+	if owner, err := user.LookupId(uid); err == nil {
+		current = owner.Username
+	}
+	r.add("%s is owned by %s instead of %s", path, current, username)
+}
+
+// mode reports a path whose permissions aren't mode
+func (r *driftReport) mode(path string, mode os.FileMode) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if perm := fi.Mode().Perm(); perm != mode {
+		r.add("%s has mode %04o instead of %04o", path, perm, mode)
+	}
+}
+
+// enabled reports a systemd service that isn't enabled
+func (r *driftReport) enabled(scope systemdScope, serviceName string) {
+	if err := scope.systemctl("is-enabled", "--quiet", serviceName).Run(); err != nil {
+		r.add("%s is not enabled", serviceName)
+	}
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// check compares the installed services with what enable would install,
+// failing when they drifted unless --fix reinstalls them. It refuses to run
+// with flags other than the ones enable ran with, which would report the
+// difference in flags as drift.
+func (e *EnableCommand) check() error {
+	manager, err := newServiceManager(e.Init)
+	if err != nil {
+		return err
+	}
+	if err := e.checkOptions(); err != nil {
+		return err
+	}
+	var report driftReport
+	if err := manager.check(e, &report); err != nil {
+		return err
+	}
+
+	if report.drifted == 0 {
+		fmt.Println("No drift found")
+		return nil
+	}
+	if !e.Fix {
+		return fmt.Errorf("found %d drifted items, run enable or enable --fix to reinstall the services", report.drifted)
+	}
+
+	fmt.Printf("\nFound %d drifted items, reinstalling the services...\n\n", report.drifted)
+	if _, err := scanRunners(e.RootDir); err != nil {
+		return err
+	}
+	if err := manager.enable(e); err != nil {
+		return err
+	}
+	return e.recordOptions()
+}
+
+// checkOptions fails when the flags differ from the ones recorded by the
+// last enable, printing both
+func (e *EnableCommand) checkOptions() error {
+	m, err := loadManifest(e.RootDir)
+	if err != nil {
+		return err
+	}
+	if m == nil || m.EnableOptions == nil {
+		fmt.Println("Warning: no enable options recorded, comparing against the current flags")
+		return nil
+	}
+	options := e.options()
+	if slices.Equal(options, m.EnableOptions) {
+		return nil
+	}
+	fmt.Printf("enable ran with: %s\n", quoteOptions(m.EnableOptions))
+	fmt.Printf("current flags:   %s\n", quoteOptions(options))
+	return fmt.Errorf("the flags differ from the ones enable ran with, run --check with the same flags, or enable to apply the new ones")
+}
+
+// quoteOptions formats flags for the shell
+func quoteOptions(options []string) string {
+	quoted := make([]string, len(options))
+	for i, option := range options {
+		quoted[i] = shellQuote(option)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (e *EnableCommand) checkMacOS(report *driftReport) error {
+	orgs, _, err := e.orgRunners()
+	if err != nil {
+		return err
+	}
+	var runnerDirs []string
+	for _, dirs := range orgs {
+		runnerDirs = append(runnerDirs, dirs...)
+	}
+	sort.Strings(runnerDirs)
+
+	exePath, err := executablePath()
+	if err != nil {
+		return err
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	config, err := e.launchAgentConfig(exePath, homeDir, runnerDirs)
+	if err != nil {
+		return err
+	}
+	content, err := e.renderLaunchAgent(config)
+	if err != nil {
+		return err
+	}
+	plistPath, err := launchAgentPath(e.RootDir)
+	if err != nil {
+		return err
+	}
+	report.file(plistPath, content)
+
+	if err := exec.Command("launchctl", "list", config.Label).Run(); err != nil {
+		report.add("LaunchAgent %s is not loaded", config.Label)
+	}
+	return nil
+}
+
+func (e *EnableCommand) checkLinux(report *driftReport) error {
+	scope := systemdScope{user: e.User}
+	orgs, orgNames, err := e.orgRunners()
+	if err != nil {
+		return err
+	}
+	exePath, err := executablePath()
+	if err != nil {
+		return err
+	}
+	unitDir, err := scope.unitDir()
+	if err != nil {
+		return err
+	}
+
+	if !e.User {
+		if err := e.checkUsers(report, orgs, orgNames); err != nil {
+			return err
+		}
+	}
+
+	if e.Layout == "runner" {
+		config, err := e.systemdRunnerConfig(scope, exePath, orgs, orgNames)
+		if err != nil {
+			return err
+		}
+		content, err := e.renderSystemdService(scope, config)
+		if err != nil {
+			return err
+		}
+		report.file(filepath.Join(unitDir, systemdRunnerUnit), content)
+		var instances []string
+		for _, runnerDir := range config.Runners {
+			serviceName := runnerServiceName(e.RootDir, runnerDir)
+			report.enabled(scope, serviceName)
+			instances = append(instances, serviceName)
+		}
+		for _, serviceName := range scope.staleInstances(unitDir, instances) {
+			report.add("%s is enabled but runs none of the runners", serviceName)
+		}
+		for _, org := range orgNames {
+			report.leftover(filepath.Join(unitDir, fmt.Sprintf("ghrunner-%s.service", org)))
+		}
+		return nil
+	}
+
+	for _, org := range orgNames {
+		config, err := e.systemdServiceConfig(scope, exePath, org, orgs[org])
+		if err != nil {
+			return err
+		}
+		content, err := e.renderSystemdService(scope, config)
+		if err != nil {
+			return err
+		}
+		report.file(filepath.Join(unitDir, config.ServiceName+".service"), content)
+		report.enabled(scope, config.ServiceName)
+	}
+	report.leftover(filepath.Join(unitDir, systemdRunnerUnit))
+	for _, serviceName := range scope.staleInstances(unitDir, nil) {
+		report.add("%s is enabled but not used by --layout, runners may run twice", serviceName)
+	}
+	return nil
+}
+
+// checkUsers checks the users of system services and their directories
+func (e *EnableCommand) checkUsers(report *driftReport, orgs map[string][]string, orgNames []string) error {
+	if _, err := e.orgUsernames(orgNames); err != nil {
+		return err
+	}
+	if err := e.checkRunnerUsernames(orgs, orgNames); err != nil {
+		return err
+	}
+	for _, org := range orgNames {
+		e.checkOrgUsers(report, org, orgs[org])
+	}
+	return nil
+}
+
+// checkOrgUsers checks the users and directory ownership prepareOrgUsers sets up
+func (e *EnableCommand) checkOrgUsers(report *driftReport, org string, runnerDirs []string) {
+	orgDir := filepath.Join(e.RootDir, org)
+
+	if e.Isolation == "runner" {
+		report.owner(orgDir, "root")
+		for _, runnerDir := range runnerDirs {
+			runnerUser := e.runnerUsername(org, runnerDir)
+			if report.userExists(runnerUser) {
+				report.owner(runnerDir, runnerUser)
+			}
+			report.mode(runnerDir, runnerDirMode)
+		}
+		return
+	}
+
+	orgUser := e.orgUsername(org)
+	if !report.userExists(orgUser) {
+		return
+	}
+	report.owner(orgDir, orgUser)
+	for _, runnerDir := range runnerDirs {
+		report.owner(runnerDir, orgUser)
+		// The runner's configuration must be readable by the service user
+		for _, name := range []string{".runner", ".credentials"} {
+			if path := filepath.Join(runnerDir, name); pathExists(path) {
+				report.owner(path, orgUser)
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// invalidContainerNameChars matches characters docker and podman don't accept in names
+var invalidContainerNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// containerName returns the container name used for a runner directory.
+// Only one container per runner exists at a time, so it is stable across runs.
+func containerName(dir string) string {
+	name := fmt.Sprintf("ghrunner-%s_%s", filepath.Base(filepath.Dir(dir)), filepath.Base(dir))
+	return invalidContainerNameChars.ReplaceAllString(name, "_")
+}
+
+// containerCommand returns the command running one iteration of the runner in
+// a fresh container, with the runner directory mounted at the same path.
+// Variables in env are passed by name so their values stay out of the arguments.
+func (s *StartCommand) containerCommand(dir string, env map[string]string) *exec.Cmd {
+	args := []string{
+		"run",
+		"--name", containerName(dir),
+		"--init",
+		"--volume", dir + ":" + dir,
+		"--workdir", dir,
+	}
+
+	// Apply the same resource limits the cgroup mode would
+	limits := s.cgroupLimits()
+	if limits.CPUWeight > 0 {
+		// cgroup v1 style shares, 1024 matches the default weight of 100
+		args = append(args, fmt.Sprintf("--cpu-shares=%d", limits.CPUWeight*1024/100))
+	}
+	if limits.CPUQuota > 0 {
+		args = append(args, fmt.Sprintf("--cpus=%.2f", float64(limits.CPUQuota)/100))
+	}
+	if limits.MemoryMax > 0 {
+		args = append(args, fmt.Sprintf("--memory=%d", int64(limits.MemoryMax)))
+	}
+	if limits.PidsMax > 0 {
+		args = append(args, fmt.Sprintf("--pids-limit=%d", limits.PidsMax))
+	}
+
+	var keys []string
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--env", key)
+	}
+
+	args = append(args, s.ContainerArgs...)
+	args = append(args, s.ContainerImage, "./run.sh", "--once")
+	return exec.Command(s.ContainerRuntime, args...)
+}
+
+// containerOOMKilled reports whether the runner's container was killed by the OOM killer
+func (s *StartCommand) containerOOMKilled(dir string) bool {
+	out, err := exec.Command(s.ContainerRuntime, "inspect", "--format", "{{.State.OOMKilled}}", containerName(dir)).Output()
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(out)) == "true"
+}
+
+// removeContainer force removes the runner's container, ignoring errors if it doesn't exist
+func (s *StartCommand) removeContainer(dir string) {
+	exec.Command(s.ContainerRuntime, "rm", "--force", containerName(dir)).Run()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContainerRunLifecycle(t *testing.T) {
+	// The fake docker logs its arguments, and FOO while running the runner,
+	// reporting the container as OOM killed
+	binDir := t.TempDir()
+	log := filepath.Join(binDir, "docker.log")
+	script := `#!/bin/sh
+echo "$*" >> ` + shellQuote(log) + `
+case "$1" in
+run) echo "env FOO=$FOO" >> ` + shellQuote(log) + `; exit 137 ;;
+inspect) echo true ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(binDir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	rootDir := t.TempDir()
+	dir := filepath.Join(rootDir, "org", "r1")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, runnerEnvFileName), []byte("FOO=bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &StartCommand{
+		RootDir:          rootDir,
+		Launch:           "shell",
+		ContainerImage:   "runner-image",
+		ContainerRuntime: "docker",
+		ContainerArgs:    []string{"--network=host"},
+		CPUQuota:         50,
+		MemoryMax:        256 << 20,
+		PidsMax:          100,
+	}
+	r := newRunner(dir, newHolds())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.runRunnerLoop(ctx, r)
+	}()
+
+	// Stop after the first iteration, the runner restarts right away
+	var calls []string
+	for deadline := time.Now().Add(10 * time.Second); len(calls) < 5; {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the first run, docker was called with %q", calls)
+		}
+		time.Sleep(10 * time.Millisecond)
+		data, _ := os.ReadFile(log)
+		calls = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	cancel()
+	<-done
+
+	want := []string{
+		"rm --force ghrunner-org_r1",
+		"run --name ghrunner-org_r1 --init --volume " + dir + ":" + dir + " --workdir " + dir +
+			" --cpus=0.50 --memory=268435456 --pids-limit=100 --env FOO --network=host runner-image ./run.sh --once",
+		"env FOO=bar",
+		"inspect --format {{.State.OOMKilled}} ghrunner-org_r1",
+		"rm --force ghrunner-org_r1",
+	}
+	if !slices.Equal(calls[:5], want) {
+		t.Errorf("docker calls:\n%s\nwant:\n%s", strings.Join(calls[:5], "\n"), strings.Join(want, "\n"))
+	}
+	if got := r.oomKills.Load(); got < 1 {
+		t.Errorf("oomKills = %d, want at least 1", got)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// controlSocketName is the control socket file created under the root directory
+const controlSocketName = "ghrunner.sock"
+
+// controlSocketPath returns where start listens by default: ghrunner.sock in
+// its root directory, which is the org directory for per-org services
+func controlSocketPath(rootDir string) string {
+	return filepath.Join(rootDir, controlSocketName)
+}
+
+// runnerSocketPath returns the socket of a ghrunner@ instance running a
+// single runner, <org>/<runner>.sock under the root directory
+func runnerSocketPath(rootDir, runnerDir string) string {
+	return filepath.Join(rootDir, filepath.FromSlash(runnerRelName(rootDir, runnerDir))+".sock")
+}
+
+// ControlRequest is sent by ghrunner ctl over the control socket
+type ControlRequest struct {
+	Action string `json:"action"`
+	Runner string `json:"runner,omitempty"`
+}
+
+// ControlResponse is returned by a running ghrunner start
+type ControlResponse struct {
+	Error   string         `json:"error,omitempty"`
+	Message string         `json:"message,omitempty"`
+	Runners []RunnerStatus `json:"runners,omitempty"`
+	Holds   []string       `json:"holds,omitempty"`
+	Webhook *WebhookStatus `json:"webhook,omitempty"`
+
+	Maintenance string `json:"maintenance,omitempty"`
+}
+
+// RunnerStatus describes a supervised runner
+type RunnerStatus struct {
+	Name     string `json:"name"`
+	Dir      string `json:"dir"`
+	Status   string `json:"status"`
+	Runs     int64  `json:"runs"`
+	Failures int64  `json:"failures"`
+	Timeouts int64  `json:"timeouts"`
+	OOMKills int64  `json:"oom_kills"`
+}
+
+type CtlCommand struct {
+	RootDir string `name:"root-dir" type:"path" help:"Root directory" env:"ROOT_RUNNERS_DIR" default:"~/.github-runners"`
+	Socket  string `name:"socket" type:"path" help:"Control socket path (defaults to the socket of the start running the runner, see README)" env:"GHRUNNER_SOCKET"`
+	Action  string `arg:"" enum:"status,pause,resume,drain,restart" help:"Action to perform (status, pause, resume, drain, restart)"`
+	Runner  string `arg:"" optional:"" help:"Runner to act on, as <org>/<name> relative to the root directory"`
+}
+
+func (c *CtlCommand) Run() error {
+	if c.Action != "status" && c.Runner == "" {
+		return fmt.Errorf("%s requires a runner", c.Action)
+	}
+
+	sockets, err := c.sockets()
+	if err != nil {
+		return err
+	}
+	for i, socket := range sockets {
+		if len(sockets) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("# %s\n", socket.path)
+		}
+		if err := c.request(socket.path, socket.runner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ctlSocket is a control socket and the runner name the start listening
+// on it knows the runner by
+type ctlSocket struct {
+	path   string
+	runner string
+}
+
+// sockets returns the control sockets to send the request to: --socket, or
+// the socket of the start serving the whole root directory if running, or
+// else the one of the runner's org service or ghrunner@ instance. Status
+// without a runner asks every org service and instance.
+func (c *CtlCommand) sockets() ([]ctlSocket, error) {
+	if c.Socket != "" {
+		return []ctlSocket{{c.Socket, c.Runner}}, nil
+	}
+	if socketPath := controlSocketPath(c.RootDir); pathExists(socketPath) {
+		return []ctlSocket{{socketPath, c.Runner}}, nil
+	}
+
+	if c.Runner != "" {
+		// Org services run start in the org directory, which knows the runner by its bare name
+		org, name, ok := strings.Cut(c.Runner, "/")
+		if !ok {
+			return nil, fmt.Errorf("runner %s must be given as <org>/<name> without a control socket in %s", c.Runner, c.RootDir)
+		}
+		orgSocket := controlSocketPath(filepath.Join(c.RootDir, org))
+		if pathExists(orgSocket) {
+			return []ctlSocket{{orgSocket, name}}, nil
+		}
+		runnerSocket := runnerSocketPath(c.RootDir, filepath.Join(c.RootDir, filepath.FromSlash(c.Runner)))
+		if pathExists(runnerSocket) {
+			return []ctlSocket{{runnerSocket, c.Runner}}, nil
+		}
+		return nil, fmt.Errorf("no control socket found for %s (is ghrunner start running?)", c.Runner)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(c.RootDir, "*", "*.sock"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no control socket found in %s (is ghrunner start running?)", c.RootDir)
+	}
+	sockets := make([]ctlSocket, len(paths))
+	for i, path := range paths {
+		sockets[i] = ctlSocket{path: path}
+	}
+	return sockets, nil
+}
+
+// request sends the action to one control socket and prints the response
+func (c *CtlCommand) request(socketPath, runner string) error {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s (is ghrunner start running?): %w", socketPath, err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(ControlRequest{Action: c.Action, Runner: runner}); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var resp ControlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+
+	if resp.Message != "" {
+		fmt.Println(resp.Message)
+	}
+	for _, rs := range resp.Runners {
+		fmt.Printf("%-40s %-10s runs=%d failures=%d timeouts=%d oom_kills=%d\n", rs.Name, rs.Status, rs.Runs, rs.Failures, rs.Timeouts, rs.OOMKills)
+	}
+	for _, hold := range resp.Holds {
+		fmt.Printf("Held: %s\n", hold)
+	}
+	if resp.Maintenance != "" {
+		fmt.Printf("Maintenance: %s\n", resp.Maintenance)
+	}
+	if wh := resp.Webhook; wh != nil {
+		fmt.Printf("Webhook: %d queued, %d started", wh.Queued, wh.Started)
+		if wh.Started > 0 {
+			fmt.Printf(", queue latency last %s, avg %s", wh.LastLatency, wh.AvgLatency)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// listenControl creates the control socket, replacing a stale one left by a previous run
+func listenControl(socketPath string) (net.Listener, error) {
+	if _, err := os.Stat(socketPath); err == nil {
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket %s is in use by another ghrunner", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("failed to remove stale control socket %s: %w", socketPath, err)
+		}
+	}
+
+	// Only the owner may control the runners. The umask is process-wide, so
+	// start listens before it starts any runner or hook.
+	oldMask := syscall.Umask(0177)
+	ln, err := net.Listen("unix", socketPath)
+	syscall.Umask(oldMask)
+	return ln, err
+}
+
+// serveControl handles control connections until the listener is closed
+func (sv *supervisor) serveControl(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Printf("Control socket error: %v\n", err)
+			continue
+		}
+		go sv.handleControl(conn)
+	}
+}
+
+func (sv *supervisor) handleControl(conn net.Conn) {
+	defer conn.Close()
+
+	var req ControlRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(ControlResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	resp := sv.control(req)
+	if resp.Error == "" && req.Action != "status" {
+		fmt.Printf("Control: %s %s\n", req.Action, req.Runner)
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+func (sv *supervisor) control(req ControlRequest) ControlResponse {
+	if req.Action == "status" {
+		holds, _ := sv.holds.active()
+		resp := ControlResponse{Runners: sv.statuses(), Holds: holds}
+		if sv.start.WebhookListen != "" {
+			resp.Webhook = sv.queue.status()
+		}
+		sv.mu.Lock()
+		resp.Maintenance = sv.maintenance
+		sv.mu.Unlock()
+		return resp
+	}
+
+	r, err := sv.find(req.Runner)
+	if err != nil {
+		return ControlResponse{Error: err.Error()}
+	}
+	if err := r.request(runnerAction(req.Action)); err != nil {
+		return ControlResponse{Error: err.Error()}
+	}
+	return ControlResponse{Message: fmt.Sprintf("%s requested for %s", req.Action, sv.runnerName(r.dir))}
+}
+
+// find looks up a runner by its path relative to the root directory,
+// its absolute directory or, when unambiguous, its bare name
+func (sv *supervisor) find(name string) (*runner, error) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	var matches []*runner
+	for dir, r := range sv.runners {
+		if dir == name || sv.runnerName(dir) == name {
+			return r, nil
+		}
+		if filepath.Base(dir) == name {
+			matches = append(matches, r)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("runner not found: %s", name)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("runner name %s is ambiguous, use <org>/<name>", name)
+	}
+}
+
+func (sv *supervisor) statuses() []RunnerStatus {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	var result []RunnerStatus
+	for dir, r := range sv.runners {
+		result = append(result, RunnerStatus{
+			Name:     sv.runnerName(dir),
+			Dir:      dir,
+			Status:   r.status(),
+			Runs:     r.runs.Load(),
+			Failures: r.failures.Load(),
+			Timeouts: r.timeouts.Load(),
+			OOMKills: r.oomKills.Load(),
+		})
+	}
+	for dir := range sv.draining {
+		result = append(result, RunnerStatus{
+			Name:   sv.runnerName(dir),
+			Dir:    dir,
+			Status: "removed",
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// runnerName returns the runner directory relative to the root directory
+func (sv *supervisor) runnerName(dir string) string {
+	rel, err := filepath.Rel(sv.start.RootDir, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return dir
+	}
+	return rel
+}
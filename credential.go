@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// runnerCredential returns the credential and environment overrides to run a
+// runner as the owner of its directory. It returns a nil credential when
+// ghrunner isn't root or the directory is owned by root.
+func runnerCredential(dir string) (*syscall.Credential, []string, error) {
+	if os.Getuid() != 0 {
+		return nil, nil, nil
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Uid == 0 {
+		return nil, nil, nil
+	}
+
+	u, err := user.LookupId(strconv.FormatUint(uint64(st.Uid), 10))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up owner of %s: %w", dir, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid gid %s of user %s: %w", u.Gid, u.Username, err)
+	}
+
+	cred := &syscall.Credential{Uid: st.Uid, Gid: uint32(gid)}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up groups of user %s: %w", u.Username, err)
+	}
+	for _, g := range groupIDs {
+		if id, err := strconv.ParseUint(g, 10, 32); err == nil {
+			cred.Groups = append(cred.Groups, uint32(id))
+		}
+	}
+
+	env := []string{
+		"HOME=" + u.HomeDir,
+		"USER=" + u.Username,
+		"LOGNAME=" + u.Username,
+	}
+	return cred, env, nil
+}
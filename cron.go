@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five field cron expression (minute, hour, day of
+// month, month, day of week) evaluated in local time
+type cronSchedule struct {
+	minute, hour, dom, month, dow []bool
+
+	// Like cron, a job runs when either day field matches if both are
+	// restricted. A field starting with * counts as unrestricted, so */2
+	// in one day field narrows the other instead, as in Vixie cron.
+	domAny, dowAny bool
+}
+
+// parseCron parses expressions such as "30 2 * * 0" or "0 */6 1-15 * mon-fri".
+// Fields accept *, numbers, ranges, lists and steps, months and weekdays also
+// their three letter English names. Sunday is 0 or 7.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	var sched cronSchedule
+	var err error
+	if sched.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute in %q: %w", expr, err)
+	}
+	if sched.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour in %q: %w", expr, err)
+	}
+	if sched.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month in %q: %w", expr, err)
+	}
+	if sched.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("invalid month in %q: %w", expr, err)
+	}
+	if sched.dow, err = parseCronField(fields[4], 0, 7, cronWeekdays); err != nil {
+		return nil, fmt.Errorf("invalid day of week in %q: %w", expr, err)
+	}
+	if sched.dow[7] {
+		sched.dow[0] = true
+	}
+	sched.domAny = strings.HasPrefix(fields[2], "*")
+	sched.dowAny = strings.HasPrefix(fields[4], "*")
+	return &sched, nil
+}
+
+var cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+var cronWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseCronField returns the values matched by a field, indexed by value.
+// names, if given, are accepted for the values starting at first.
+func parseCronField(field string, first, last int, names []string) ([]bool, error) {
+	matches := make([]bool, last+1)
+
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return first + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < first || n > last {
+			return 0, fmt.Errorf("%q is not a number between %d and %d", s, first, last)
+		}
+		return n, nil
+	}
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := first, last
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = value(from); err != nil {
+				return nil, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = value(to); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				// "5/15" means every 15 starting at 5
+				hi = last
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("invalid range %q", rangePart)
+			}
+		}
+
+		for n := lo; n <= hi; n += step {
+			matches[n] = true
+		}
+	}
+	return matches, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom[t.Day()]
+	dow := c.dow[int(t.Weekday())]
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t matching the schedule, or the zero time
+// if there is none within five years (e.g. February 30th)
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !c.month[int(t.Month())] || !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Wednesday
+	from := time.Date(2026, 1, 14, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", from, time.Date(2026, 1, 14, 10, 31, 0, 0, time.UTC)},
+		{"* * * * *", from.Add(30 * time.Second), time.Date(2026, 1, 14, 10, 31, 0, 0, time.UTC)},
+		{"30 2 * * *", from, time.Date(2026, 1, 15, 2, 30, 0, 0, time.UTC)},
+		{"0 */6 * * *", from, time.Date(2026, 1, 14, 12, 0, 0, 0, time.UTC)},
+		{"5/15 * * * *", from, time.Date(2026, 1, 14, 10, 35, 0, 0, time.UTC)},
+		{"0 3 * * sun", from, time.Date(2026, 1, 18, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", from, time.Date(2026, 1, 18, 3, 0, 0, 0, time.UTC)},
+		{"0 0 * * mon-fri", time.Date(2026, 1, 16, 12, 0, 0, 0, time.UTC), time.Date(2026, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 jan *", from, time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", from, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC), time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted
+		{"0 0 20 * fri", from, time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC)},
+		// A day field starting with * only narrows the other
+		{"0 0 */2 * fri", from, time.Date(2026, 1, 23, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * */2", from, time.Date(2026, 6, 13, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 feb *", from, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			sched, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q): %v", tt.expr, err)
+			}
+			if got := sched.next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextMaintenance(t *testing.T) {
+	window, err := parseMaintenanceWindow("0 3 * * sun 2h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	windows := []maintenanceWindow{window}
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		wantStart time.Time
+	}{
+		{"before", time.Date(2026, 1, 14, 10, 0, 0, 0, time.UTC), time.Date(2026, 1, 18, 3, 0, 0, 0, time.UTC)},
+		{"open", time.Date(2026, 1, 18, 4, 0, 0, 0, time.UTC), time.Date(2026, 1, 18, 3, 0, 0, 0, time.UTC)},
+		{"after", time.Date(2026, 1, 18, 5, 0, 0, 0, time.UTC), time.Date(2026, 1, 25, 3, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := nextMaintenance(windows, tt.now)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantStart.Add(2*time.Hour)) {
+				t.Errorf("nextMaintenance(%s) = %s, %s, want %s, %s", tt.now, start, end, tt.wantStart, tt.wantStart.Add(2*time.Hour))
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around changes
+const diffContext = 3
+
+type diffLine struct {
+	op   byte   // ' ', '-' or '+'
+	text string // line with its newline, which the last line may lack
+}
+
+// unifiedDiff returns a unified diff turning a into b, or "" if they are equal.
+// Service files are short, so a plain LCS table is fast enough.
+func unifiedDiff(nameA, nameB, a, b string) string {
+	if a == b {
+		return ""
+	}
+	linesA := splitLines(a)
+	linesB := splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of linesA[i:] and linesB[j:]
+	lcs := make([][]int, len(linesA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(linesB)+1)
+	}
+	for i := len(linesA) - 1; i >= 0; i-- {
+		for j := len(linesB) - 1; j >= 0; j-- {
+			if linesA[i] == linesB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(linesA) || j < len(linesB) {
+		switch {
+		case i < len(linesA) && j < len(linesB) && linesA[i] == linesB[j]:
+			lines = append(lines, diffLine{' ', linesA[i]})
+			i++
+			j++
+		case i < len(linesA) && (j == len(linesB) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', linesA[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', linesB[j]})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+	// Line numbers in a and b before each diff line
+	posA, posB := 0, 0
+	for k := 0; k < len(lines); {
+		if lines[k].op == ' ' {
+			posA++
+			posB++
+			k++
+			continue
+		}
+
+		// A hunk starts with the context before the change and extends over
+		// changes separated by less than twice the context
+		start := max(k-diffContext, 0)
+		end := k
+		for end < len(lines) {
+			if lines[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*diffContext {
+				end = min(end+diffContext, len(lines))
+				break
+			}
+			end = next
+		}
+
+		startA, startB := posA-(k-start), posB-(k-start)
+		countA, countB := 0, 0
+		for _, l := range lines[start:end] {
+			if l.op != '+' {
+				countA++
+			}
+			if l.op != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(startA, countA), hunkRange(startB, countB))
+		for _, l := range lines[start:end] {
+			out.WriteByte(l.op)
+			out.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		for _, l := range lines[k:end] {
+			if l.op != '+' {
+				posA++
+			}
+			if l.op != '-' {
+				posB++
+			}
+		}
+		k = end
+	}
+	return out.String()
+}
+
+// splitLines splits a file into lines keeping their newlines, so a missing
+// newline at the end of the file shows up as a change. An empty file has none.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// hunkRange formats the start line and length of a hunk side
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
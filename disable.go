@@ -2,38 +2,38 @@ package main
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
-	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 type DisableCommand struct {
 	RootDir string `name:"root-dir" type:"path" help:"Root directory" env:"ROOT_RUNNERS_DIR" default:"~/.github-runners"`
+	Init    string `name:"init" enum:"auto,launchd,systemd,openrc,runit,supervisord" help:"Service manager (auto, launchd, systemd, openrc, runit, supervisord), auto detects the running one" env:"GHRUNNER_INIT" default:"auto"`
+	User    bool   `name:"user" help:"Remove systemd user services of the current user instead of system services (Linux)" env:"GHRUNNER_SYSTEMD_USER"`
+
+	PurgeUsers       bool `name:"purge-users" help:"Also delete the Linux users enable created, with their home directories" env:"GHRUNNER_PURGE_USERS"`
+	RestoreOwnership bool `name:"restore-ownership" help:"Give the org and runner directories back to the user running disable (through sudo)" env:"GHRUNNER_RESTORE_OWNERSHIP"`
 }
 
 func (d *DisableCommand) Run() error {
-	switch runtime.GOOS {
-	case "darwin":
-		return d.disableMacOS()
-	case "linux":
-		return d.disableLinux()
-	default:
-		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+	manager, err := newServiceManager(d.Init)
+	if err != nil {
+		return err
 	}
+	return manager.disable(d)
 }
 
 func (d *DisableCommand) disableMacOS() error {
-	homeDir, err := os.UserHomeDir()
+	plistPath, err := launchAgentPath(d.RootDir)
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+		return err
 	}
-	launchAgentsDir := filepath.Join(homeDir, "Library", "LaunchAgents")
-
-	label := "com.github.actions.runner"
-	plistPath := filepath.Join(launchAgentsDir, label+".plist")
 
 	// Unload if loaded
 	cmd := exec.Command("launchctl", "unload", plistPath)
@@ -49,70 +49,159 @@ func (d *DisableCommand) disableMacOS() error {
 	}
 
 	fmt.Printf("Removed LaunchAgent: %s\n", plistPath)
-	return nil
+	return updateManifest(d.RootDir, func(m *Manifest) error {
+		m.setServices("launchd", nil)
+		m.EnableOptions = nil
+		return nil
+	})
 }
 
 func (d *DisableCommand) disableLinux() error {
-	// Check if running as root
-	currentUser, err := user.Current()
-	if err != nil {
-		return fmt.Errorf("failed to get current user: %w", err)
+	scope := systemdScope{user: d.User}
+	if err := scope.requireRoot("disable"); err != nil {
+		return err
+	}
+	if d.User && (d.PurgeUsers || d.RestoreOwnership) {
+		return fmt.Errorf("--purge-users and --restore-ownership undo changes of system services and can't be used with --user")
 	}
-	if currentUser.Uid != "0" {
-		return fmt.Errorf("disable command on Linux requires root privileges. Please run with sudo")
+	unitDir, err := scope.unitDir()
+	if err != nil {
+		return err
 	}
 
-	runnerDirs, err := searchRunnerDirs(d.RootDir)
+	services, err := scope.installedServices(d.RootDir)
 	if err != nil {
-		return fmt.Errorf("failed to search runner dirs: %w", err)
+		return err
+	}
+	if len(services) == 0 && !d.PurgeUsers && !d.RestoreOwnership {
+		fmt.Println("No services found, nothing to disable")
+		return nil
 	}
 
-	// Find all unique orgs
-	orgs := make(map[string]bool)
-	for _, runnerDir := range runnerDirs {
-		relPath, err := filepath.Rel(d.RootDir, runnerDir)
-		if err != nil {
+	// Template instances come before the template unit
+	services = append(scope.staleInstances(unitDir, services), services...)
+	for _, serviceName := range services {
+		if strings.Contains(serviceName, "@") && !strings.HasSuffix(serviceName, "@") {
+			scope.disableInstance(serviceName)
 			continue
 		}
-		parts := strings.Split(relPath, string(filepath.Separator))
-		if len(parts) >= 1 {
-			orgs[parts[0]] = true
-		}
+		scope.removeService(unitDir, serviceName)
 	}
 
-	if len(orgs) == 0 {
-		fmt.Println("No runners found, nothing to disable")
-		return nil
+	// Reload systemd
+	cmd := scope.systemctl("daemon-reload")
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
 	}
 
-	for org := range orgs {
-		serviceName := fmt.Sprintf("ghrunner-%s", org)
-		servicePath := filepath.Join("/etc/systemd/system", serviceName+".service")
+	err = updateManifest(d.RootDir, func(m *Manifest) error {
+		m.setServices(scope.manager(), nil)
+		m.EnableOptions = nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		// Stop the service
-		cmd := exec.Command("systemctl", "stop", serviceName)
-		_ = cmd.Run() // Ignore errors if not running
+	fmt.Println("\nSystemd services removed.")
+	return d.removeUsers()
+}
 
-		// Disable the service
-		cmd = exec.Command("systemctl", "disable", serviceName)
-		_ = cmd.Run() // Ignore errors if not enabled
+// removeUsers undoes the user changes of enable requested with
+// --restore-ownership and --purge-users
+func (d *DisableCommand) removeUsers() error {
+	// Hand the directories back before their owners are deleted
+	if d.RestoreOwnership {
+		if err := d.restoreOwnership(); err != nil {
+			return err
+		}
+	}
+	if d.PurgeUsers {
+		return d.purgeUsers()
+	}
+	return nil
+}
 
-		// Remove service file
-		if err := os.Remove(servicePath); err != nil {
-			if !os.IsNotExist(err) {
-				fmt.Printf("Warning: failed to remove %s: %v\n", servicePath, err)
-			}
-		} else {
-			fmt.Printf("Removed systemd service: %s\n", serviceName)
+// restoreOwnership gives the org directories back to the user who ran
+// disable through sudo, or to the current user
+func (d *DisableCommand) restoreOwnership() error {
+	runnerDirs, err := manifestRunners(d.RootDir)
+	if err != nil {
+		return err
+	}
+	var orgs []string
+	for _, runnerDir := range runnerDirs {
+		org, _, _ := strings.Cut(runnerRelName(d.RootDir, runnerDir), "/")
+		if !slices.Contains(orgs, org) {
+			orgs = append(orgs, org)
 		}
 	}
 
-	// Reload systemd
-	cmd := exec.Command("systemctl", "daemon-reload")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to reload systemd: %w", err)
+	uid, gid := os.Getenv("SUDO_UID"), os.Getenv("SUDO_GID")
+	if uid == "" || gid == "" {
+		uid, gid = strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
 	}
 
-	fmt.Println("\nSystemd services removed.")
+	for _, org := range orgs {
+		orgDir := filepath.Join(d.RootDir, org)
+		cmd := exec.Command("chown", "-R", uid+":"+gid, orgDir)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to change ownership of %s: %w", orgDir, err)
+		}
+		fmt.Printf("Restored ownership of %s to %s:%s\n", orgDir, uid, gid)
+	}
 	return nil
 }
+
+// purgeUsers deletes the users recorded in the manifest, which enable
+// created. Users that already existed are never recorded.
+func (d *DisableCommand) purgeUsers() error {
+	m, err := loadManifest(d.RootDir)
+	if err != nil {
+		return err
+	}
+	if m == nil || len(m.CreatedUsers) == 0 {
+		fmt.Println("No users created by ghrunner, nothing to purge")
+		return nil
+	}
+
+	var deleted []string
+	for _, username := range m.CreatedUsers {
+		if _, err := user.Lookup(username); err != nil {
+			fmt.Printf("User %s no longer exists\n", username)
+			deleted = append(deleted, username)
+			continue
+		}
+		cmd := exec.Command("userdel", "--remove", username)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("Warning: failed to delete user %s: %v\n", username, err)
+			continue
+		}
+		fmt.Printf("Deleted user: %s%s\n", username, usernameOwner(m, username))
+		deleted = append(deleted, username)
+	}
+
+	return updateManifest(d.RootDir, func(m *Manifest) error {
+		m.CreatedUsers = slices.DeleteFunc(m.CreatedUsers, func(username string) bool {
+			return slices.Contains(deleted, username)
+		})
+		maps.DeleteFunc(m.Usernames, func(_, username string) bool {
+			return slices.Contains(deleted, username)
+		})
+		return nil
+	})
+}
+
+// usernameOwner describes which org or runner a user ran, from the manifest
+func usernameOwner(m *Manifest, username string) string {
+	for owner, name := range m.Usernames {
+		if name == username {
+			return fmt.Sprintf(" (%s)", owner)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// diskHoldKey identifies the disk watchdog hold in the supervisor
+const diskHoldKey = "disk"
+
+// freeSpace returns the space available to unprivileged users on the filesystem holding path
+func freeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// lowDiskPaths returns a description of every watched path below MinFreeSpace
+func (s *StartCommand) lowDiskPaths() []string {
+	var low []string
+	for _, path := range append([]string{s.RootDir}, s.DiskPaths...) {
+		free, err := freeSpace(path)
+		if err != nil {
+			fmt.Printf("Disk watchdog failed to check %s: %v\n", path, err)
+			continue
+		}
+		if free < uint64(s.MinFreeSpace) {
+			low = append(low, fmt.Sprintf("%s has %s free", path, ByteSize(free).Human()))
+		}
+	}
+	return low
+}
+
+// runDiskCleanup runs DiskCleanup in the root directory, or with
+// --run-as-owner in each runner directory as the runner's user, so it
+// never runs as root. It isn't limited by HookTimeout, pruning large caches
+// takes a while.
+func (s *StartCommand) runDiskCleanup(ctx context.Context, sv *supervisor) {
+	if !s.RunAsOwner {
+		if err := s.runCommand(ctx, "disk-cleanup", s.DiskCleanup, s.RootDir); err != nil {
+			fmt.Printf("Disk watchdog cleanup failed: %v\n", err)
+		}
+		return
+	}
+	for _, dir := range sv.runnerDirs() {
+		if err := s.runCommand(ctx, "disk-cleanup", s.DiskCleanup, dir, "GHRUNNER_RUNNER_DIR="+dir); err != nil {
+			fmt.Printf("Disk watchdog cleanup failed for runner %s: %v\n", dir, err)
+		}
+	}
+}
+
+// watchDisk holds all runners while a watched path is below MinFreeSpace,
+// running DiskCleanup each time the check fails
+func (s *StartCommand) watchDisk(ctx context.Context, sv *supervisor) {
+	ticker := time.NewTicker(s.DiskCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		low := s.lowDiskPaths()
+		if len(low) > 0 && s.DiskCleanup != "" {
+			fmt.Printf("Low disk space (%s), running cleanup...\n", strings.Join(low, ", "))
+			s.runDiskCleanup(ctx, sv)
+			low = s.lowDiskPaths()
+		}
+
+		if len(low) > 0 {
+			sv.hold(diskHoldKey, fmt.Sprintf("low disk space, %s (minimum %s)", strings.Join(low, ", "), s.MinFreeSpace.Human()))
+		} else {
+			sv.release(diskHoldKey)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
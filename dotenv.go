@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// dotEnvManagedPrefix marks the comment listing the keys ghrunner wrote to a
+// .env file, so keys dropped from the configuration are removed again
+const dotEnvManagedPrefix = "# ghrunner-managed: "
+
+// parseDotEnv reads KEY=VALUE lines from a dotenv file. Blank lines and
+// comments are skipped, an "export " prefix is allowed and values may be
+// wrapped in single or double quotes.
+func parseDotEnv(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: invalid line", path, lineNo)
+		}
+
+		value = strings.TrimSpace(value)
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+			value = unquoted
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// updateDotEnv sets the given keys in a runner's .env file, keeping every
+// other line as it is, and removes keys it set on a previous call that are no
+// longer given. The file is created if missing. A private file is only
+// readable by its owner. The runner reads .env line by line without
+// unquoting, so values can't contain line breaks.
+func updateDotEnv(path string, values map[string]string, private bool) error {
+	for key, value := range values {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("value of %s contains a line break, which %s can't hold", key, filepath.Base(path))
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Keys written by a previous call
+	previous := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		if list, ok := strings.CutPrefix(scanner.Text(), dotEnvManagedPrefix); ok {
+			for _, key := range strings.Split(list, ",") {
+				previous[key] = true
+			}
+		}
+	}
+	if len(values) == 0 && len(previous) == 0 {
+		return nil
+	}
+
+	var lines []string
+	seen := make(map[string]bool)
+	scanner = bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, dotEnvManagedPrefix) {
+			continue
+		}
+		key, _, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if value, managed := values[key]; ok && managed {
+			if seen[key] {
+				continue
+			}
+			line = key + "=" + value
+			seen[key] = true
+		} else if ok && previous[key] {
+			// No longer configured
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	// Append keys that weren't in the file yet in a stable order
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !seen[key] {
+			lines = append(lines, key+"="+values[key])
+		}
+	}
+	if len(keys) > 0 {
+		lines = append(lines, dotEnvManagedPrefix+strings.Join(keys, ","))
+	}
+
+	content := strings.Join(lines, "\n") + "\n"
+	if content == string(data) {
+		return nil
+	}
+
+	// Keep the owner of an existing file, or use the runner directory's owner
+	mode := os.FileMode(0644)
+	if private {
+		mode = 0600
+	}
+	owner, err := os.Stat(path)
+	if err != nil {
+		owner, err = os.Stat(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Write to a temp file first so the runner never reads a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".env.tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	if st, ok := owner.Sys().(*syscall.Stat_t); ok && os.Getuid() == 0 {
+		if err := os.Chown(tmp.Name(), int(st.Uid), int(st.Gid)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
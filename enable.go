@@ -6,15 +6,40 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
-	"runtime"
+	"sort"
 	"strings"
-	"text/template"
+	"time"
 )
 
 type EnableCommand struct {
-	RootDir string `name:"root-dir" type:"path" help:"Root directory" env:"ROOT_RUNNERS_DIR" default:"~/.github-runners"`
+	RootDir     string        `name:"root-dir" type:"path" help:"Root directory" env:"ROOT_RUNNERS_DIR" default:"~/.github-runners"`
+	Init        string        `name:"init" enum:"auto,launchd,systemd,openrc,runit,supervisord" help:"Service manager (auto, launchd, systemd, openrc, runit, supervisord), auto detects the running one" env:"GHRUNNER_INIT" default:"auto"`
+	StopTimeout time.Duration `name:"stop-timeout" help:"How long the service waits for busy runners to finish their current job on shutdown (0 waits forever)" env:"GHRUNNER_STOP_TIMEOUT" default:"30s"`
+	Isolation   string        `name:"isolation" enum:"org,runner" help:"Linux user isolation: one user per org, or one user per runner (org, runner)" env:"GHRUNNER_ISOLATION" default:"org"`
+	User        bool          `name:"user" help:"Install systemd user services running as the current user, without root (Linux)" env:"GHRUNNER_SYSTEMD_USER"`
+	Layout      string        `name:"layout" enum:"org,runner" help:"systemd services: one per org, or a ghrunner@ instance per runner (org, runner)" env:"GHRUNNER_LAYOUT" default:"org"`
+
+	UserPrefix string            `name:"user-prefix" help:"Prefix of the Linux user created for each org, e.g. ghr- for ghr-<org>" env:"GHRUNNER_USER_PREFIX"`
+	Usernames  map[string]string `name:"username" help:"Linux user of an org as ORG=USER, overriding --user-prefix (repeatable)" env:"GHRUNNER_USERNAMES"`
+	AdoptUsers bool              `name:"adopt-users" help:"Run orgs as existing regular users instead of refusing to take them over" env:"GHRUNNER_ADOPT_USERS"`
+
+	Hardening        string   `name:"hardening" enum:"none,compatible,strict" help:"systemd sandboxing preset (none, compatible, strict)" env:"GHRUNNER_HARDENING" default:"compatible"`
+	ReadWritePaths   []string `name:"read-write-paths" help:"Additional paths services may write to with --hardening=strict" env:"GHRUNNER_READ_WRITE_PATHS"`
+	ServiceMemoryMax ByteSize `name:"service-memory-max" help:"Memory limit of each service, all its runners together (systemd MemoryMax)" env:"GHRUNNER_SERVICE_MEMORY_MAX" default:"0"`
+	ServiceCPUQuota  int      `name:"service-cpu-quota" help:"CPU quota of each service in percent of one CPU (systemd CPUQuota)" env:"GHRUNNER_SERVICE_CPU_QUOTA"`
+	ServiceTasksMax  int      `name:"service-tasks-max" help:"Maximum number of processes of each service (systemd TasksMax)" env:"GHRUNNER_SERVICE_TASKS_MAX"`
+	Environment      []string `name:"environment" sep:"none" help:"KEY=VALUE set in the service environment (repeatable)" env:"GHRUNNER_SERVICE_ENVIRONMENT"`
+	EnvironmentFiles []string `name:"environment-file" help:"File the service loads its environment from, prefix with - to ignore a missing file (repeatable)" env:"GHRUNNER_SERVICE_ENVIRONMENT_FILES"`
+
+	ServiceTemplates map[string]string `name:"service-template" mapsep:";" help:"Go template replacing a built-in service file as KIND=PATH, KIND being launchd, systemd, systemd-runner, openrc, runit or supervisord (repeatable)" env:"GHRUNNER_SERVICE_TEMPLATES"`
+
+	Check bool `name:"check" help:"Only compare the installed services, users and directory ownership with what enable would install, failing on drift"`
+	Fix   bool `name:"fix" help:"Like --check, but reinstall the services when they drifted"`
 }
 
+// launchAgentLabel is the label and plist name of the macOS LaunchAgent
+const launchAgentLabel = "com.github.actions.runner"
+
 // LaunchAgent plist template for macOS
 // Single LaunchAgent that runs ghrunner start
 const launchAgentTemplate = `<?xml version="1.0" encoding="UTF-8"?>
@@ -28,11 +53,23 @@ const launchAgentTemplate = `<?xml version="1.0" encoding="UTF-8"?>
         <string>{{.ExePath}}</string>
         <string>start</string>
         <string>--root-dir={{.RootDir}}</string>
+        <string>--stop-timeout={{.StopTimeout}}</string>
     </array>
     <key>RunAtLoad</key>
     <true/>
     <key>KeepAlive</key>
     <true/>
+    <key>ExitTimeOut</key>
+    <integer>{{.ExitTimeOut}}</integer>
+{{- if .Env}}
+    <key>EnvironmentVariables</key>
+    <dict>
+{{- range .Env}}
+        <key>{{xml .Key}}</key>
+        <string>{{xml .Value}}</string>
+{{- end}}
+    </dict>
+{{- end}}
     <key>StandardOutPath</key>
     <string>{{.LogPath}}/ghrunner.log</string>
     <key>StandardErrorPath</key>
@@ -42,65 +79,480 @@ const launchAgentTemplate = `<?xml version="1.0" encoding="UTF-8"?>
 `
 
 // systemd service template for Linux
-// Each org gets its own service running as its own user
+// Each org gets its own service running as its own user, or as root
+// dropping privileges per runner with --isolation=runner.
+// User services run as the user owning the service manager.
 const systemdServiceTemplate = `[Unit]
 Description=GitHub Actions Runner - {{.Org}}
-After=network.target
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=simple
+{{- if .User}}
+User={{.User}}
+{{- end}}
+{{- range .Environment}}
+Environment={{.}}
+{{- end}}
+{{- range .EnvironmentFiles}}
+EnvironmentFile={{.}}
+{{- end}}
+ExecStart={{.ExePath}} start --root-dir={{.OrgDir}} --stop-timeout={{.StopTimeout}}{{if .RunAsOwner}} --run-as-owner{{end}}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=always
+RestartSec=5
+KillMode=mixed
+Delegate=yes
+TimeoutStopSec={{.TimeoutStopSec}}
+{{- range .Resources}}
+{{.}}
+{{- end}}
+{{- range .Hardening}}
+{{.}}
+{{- end}}
+
+[Install]
+WantedBy={{.WantedBy}}
+`
+
+// systemdRunnerUnit is the template unit of the per-runner layout
+const systemdRunnerUnit = "ghrunner@.service"
+
+// systemd template unit for the per-runner layout, instances are the
+// escaped <org>/<runner> and run a single runner each
+const systemdRunnerTemplate = `[Unit]
+Description=GitHub Actions Runner - %I
+Wants=network-online.target
+After=network-online.target
 
 [Service]
 Type=simple
+{{- if .User}}
 User={{.User}}
-ExecStart={{.ExePath}} start --root-dir={{.OrgDir}}
+{{- end}}
+{{- range .Environment}}
+Environment={{.}}
+{{- end}}
+{{- range .EnvironmentFiles}}
+EnvironmentFile={{.}}
+{{- end}}
+ExecStart={{.ExePath}} start --root-dir={{.RootDir}} "--runner=%I" "--socket={{.RootDir}}/%I.sock" --stop-timeout={{.StopTimeout}}{{if .RunAsOwner}} --run-as-owner{{end}}
+ExecReload=/bin/kill -HUP $MAINPID
 Restart=always
 RestartSec=5
+KillMode=mixed
+Delegate=yes
+TimeoutStopSec={{.TimeoutStopSec}}
+{{- range .Resources}}
+{{.}}
+{{- end}}
+{{- range .Hardening}}
+{{.}}
+{{- end}}
 
 [Install]
-WantedBy=multi-user.target
+WantedBy={{.WantedBy}}
 `
 
+// EnvVar is a variable set in the service environment with --environment
+type EnvVar struct {
+	Key   string
+	Value string
+}
+
+// EnvFile is a file the service loads its environment from with --environment-file
+type EnvFile struct {
+	Path     string
+	Optional bool // prefixed with -, a missing file is ignored
+}
+
+// LaunchAgentConfig is the data a LaunchAgent template is rendered with
 type LaunchAgentConfig struct {
-	Label   string
-	ExePath string
-	RootDir string
-	LogPath string
+	Label       string        // launchd label, also the plist file name
+	ExePath     string        // resolved path of the ghrunner executable
+	RootDir     string        // root directory passed to start
+	LogPath     string        // directory of the log files
+	StopTimeout time.Duration // --stop-timeout passed to start
+	ExitTimeOut int           // seconds launchd waits after SIGTERM, 0 for no limit
+	Env         []EnvVar      // variables from --environment
+	Runners     []string      // runner directories found under RootDir
+	Labels      []string      // labels setup registered the runners with
 }
 
+// SystemdServiceConfig is the data a systemd unit template is rendered
+// with, and the OpenRC, runit and supervisord services of an org
 type SystemdServiceConfig struct {
-	Org     string
-	OrgDir  string
-	User    string
-	ExePath string
+	ServiceName    string        // unit name without .service
+	Org            string        // org directory name
+	OrgDir         string        // org directory, the root directory of the service
+	RootDir        string        // root directory of all orgs
+	User           string        // User= of the service, empty for user services
+	Home           string        // home directory of User, for service managers not setting HOME
+	ExePath        string        // resolved path of the ghrunner executable
+	StopTimeout    time.Duration // --stop-timeout passed to start
+	TimeoutStopSec string        // seconds systemd waits for the service to stop, or infinity
+	StopSeconds    int           // seconds other service managers wait, 0 for no limit
+	RunAsOwner     bool          // whether start runs each runner as its directory owner
+	WantedBy       string        // install target
+	Runners        []string      // runner directories of the org
+	Labels         []string      // labels setup registered the runners with
+
+	// Env holds the variables from --environment, Environment the same as
+	// quoted KEY=VALUE assignments ready for Environment=
+	Env              []EnvVar
+	Environment      []string
+	EnvironmentFiles []string
+	EnvFiles         []EnvFile // EnvironmentFiles for shell scripts
+
+	// Resources and Hardening are complete directives such as MemoryMax=1073741824
+	Resources []string
+	Hardening []string
 }
 
-func (e *EnableCommand) Run() error {
-	switch runtime.GOOS {
-	case "darwin":
-		return e.enableMacOS()
-	case "linux":
-		return e.enableLinux()
-	default:
-		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+// serviceStopSeconds returns how long the service manager should wait for
+// ghrunner to exit after asking it to stop, or 0 to wait forever.
+// It leaves room for runners to be interrupted and killed after the stop
+// timeout, and for their post-run hooks.
+func serviceStopSeconds(stopTimeout time.Duration) int {
+	if stopTimeout <= 0 {
+		return 0
 	}
+	return int((stopTimeout + 3*runnerKillGrace + shutdownHookTimeout).Seconds())
 }
 
-func (e *EnableCommand) enableMacOS() error {
-	runnerDirs, err := searchRunnerDirs(e.RootDir)
-	if err != nil {
-		return fmt.Errorf("failed to search runner dirs: %w", err)
+// systemdStopTimeout formats serviceStopSeconds for TimeoutStopSec
+func systemdStopTimeout(stopTimeout time.Duration) string {
+	seconds := serviceStopSeconds(stopTimeout)
+	if seconds == 0 {
+		return "infinity"
 	}
+	return fmt.Sprintf("%d", seconds)
+}
 
-	if len(runnerDirs) == 0 {
-		return fmt.Errorf("no runners found in %s", e.RootDir)
+// systemdResources returns the resource control directives for each service
+func (e *EnableCommand) systemdResources() []string {
+	var directives []string
+	if e.ServiceMemoryMax > 0 {
+		directives = append(directives, fmt.Sprintf("MemoryMax=%d", int64(e.ServiceMemoryMax)))
+	}
+	if e.ServiceCPUQuota > 0 {
+		directives = append(directives, fmt.Sprintf("CPUQuota=%d%%", e.ServiceCPUQuota))
+	}
+	if e.ServiceTasksMax > 0 {
+		directives = append(directives, fmt.Sprintf("TasksMax=%d", e.ServiceTasksMax))
+	}
+	return directives
+}
+
+// environment parses the --environment variables
+func (e *EnableCommand) environment() ([]EnvVar, error) {
+	var env []EnvVar
+	for _, kv := range e.Environment {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --environment %q, expected KEY=VALUE", kv)
+		}
+		env = append(env, EnvVar{Key: key, Value: value})
 	}
+	return env, nil
+}
 
-	// Get executable path
+// envFiles parses the --environment-file paths
+func (e *EnableCommand) envFiles() []EnvFile {
+	var files []EnvFile
+	for _, path := range e.EnvironmentFiles {
+		optional := strings.HasPrefix(path, "-")
+		files = append(files, EnvFile{Path: strings.TrimPrefix(path, "-"), Optional: optional})
+	}
+	return files
+}
+
+// options returns the flags shaping the installed services and users in a
+// stable order, recorded in the manifest so --check can tell when it runs
+// with different ones
+func (e *EnableCommand) options() []string {
+	options := []string{
+		"--stop-timeout=" + e.StopTimeout.String(),
+		"--isolation=" + e.Isolation,
+		"--layout=" + e.Layout,
+		"--hardening=" + e.Hardening,
+	}
+	if e.User {
+		options = append(options, "--user")
+	}
+	if e.UserPrefix != "" {
+		options = append(options, "--user-prefix="+e.UserPrefix)
+	}
+	orgs := make([]string, 0, len(e.Usernames))
+	for org := range e.Usernames {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	for _, org := range orgs {
+		options = append(options, "--username="+org+"="+e.Usernames[org])
+	}
+	for _, path := range e.ReadWritePaths {
+		options = append(options, "--read-write-paths="+path)
+	}
+	if e.ServiceMemoryMax > 0 {
+		options = append(options, fmt.Sprintf("--service-memory-max=%d", int64(e.ServiceMemoryMax)))
+	}
+	if e.ServiceCPUQuota > 0 {
+		options = append(options, fmt.Sprintf("--service-cpu-quota=%d", e.ServiceCPUQuota))
+	}
+	if e.ServiceTasksMax > 0 {
+		options = append(options, fmt.Sprintf("--service-tasks-max=%d", e.ServiceTasksMax))
+	}
+	for _, kv := range e.Environment {
+		options = append(options, "--environment="+kv)
+	}
+	for _, path := range e.EnvironmentFiles {
+		options = append(options, "--environment-file="+path)
+	}
+	kinds := make([]string, 0, len(e.ServiceTemplates))
+	for kind := range e.ServiceTemplates {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		options = append(options, "--service-template="+kind+"="+e.ServiceTemplates[kind])
+	}
+	return options
+}
+
+// executablePath returns the resolved path of the running ghrunner binary
+func executablePath() (string, error) {
 	exePath, err := os.Executable()
 	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
+		return "", fmt.Errorf("failed to get executable path: %w", err)
 	}
 	exePath, err = filepath.EvalSymlinks(exePath)
 	if err != nil {
-		return fmt.Errorf("failed to resolve executable path: %w", err)
+		return "", fmt.Errorf("failed to resolve executable path: %w", err)
+	}
+	return exePath, nil
+}
+
+// orgRunners groups the runners found under the root directory by org,
+// returning the org names in a stable order
+func (e *EnableCommand) orgRunners() (map[string][]string, []string, error) {
+	runnerDirs, err := manifestRunners(e.RootDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(runnerDirs) == 0 {
+		return nil, nil, fmt.Errorf("no runners found in %s", e.RootDir)
+	}
+
+	orgs := make(map[string][]string)
+	var names []string
+	for _, runnerDir := range runnerDirs {
+		relPath, err := filepath.Rel(e.RootDir, runnerDir)
+		if err != nil {
+			continue
+		}
+		parts := strings.Split(relPath, string(filepath.Separator))
+		if len(parts) >= 1 {
+			if _, ok := orgs[parts[0]]; !ok {
+				names = append(names, parts[0])
+			}
+			orgs[parts[0]] = append(orgs[parts[0]], runnerDir)
+		}
+	}
+	sort.Strings(names)
+	return orgs, names, nil
+}
+
+// launchAgentConfig returns the data of the LaunchAgent
+func (e *EnableCommand) launchAgentConfig(exePath, homeDir string, runnerDirs []string) (LaunchAgentConfig, error) {
+	env, err := e.environment()
+	if err != nil {
+		return LaunchAgentConfig{}, err
+	}
+	return LaunchAgentConfig{
+		Label:       launchAgentLabel,
+		ExePath:     exePath,
+		RootDir:     e.RootDir,
+		LogPath:     filepath.Join(homeDir, "Library", "Logs", "ghrunner"),
+		StopTimeout: e.StopTimeout,
+		// launchd treats 0 as no timeout
+		ExitTimeOut: serviceStopSeconds(e.StopTimeout),
+		Env:         env,
+		Runners:     runnerDirs,
+		Labels:      runnerLabels(e.RootDir, runnerDirs),
+	}, nil
+}
+
+// serviceUsername returns the user an org's systemd service runs as
+func (e *EnableCommand) serviceUsername(org string) string {
+	switch {
+	case e.User:
+		// The user manager runs the service as the current user,
+		// who already owns the runner directories
+		return ""
+	case e.Isolation == "runner" || e.Layout == "runner":
+		// start drops privileges to each runner directory's owner
+		return "root"
+	default:
+		return e.orgUsername(org)
+	}
+}
+
+// runnerHomes returns the home directories of the users running an org's runners
+func (e *EnableCommand) runnerHomes(org string, runnerDirs []string) []string {
+	switch {
+	case e.User:
+		return nil
+	case e.Isolation == "runner":
+		var homes []string
+		for _, runnerDir := range runnerDirs {
+			homes = append(homes, userHomeDir(e.runnerUsername(org, runnerDir)))
+		}
+		return homes
+	default:
+		return []string{userHomeDir(e.orgUsername(org))}
+	}
+}
+
+// systemdEnvironment quotes the --environment variables for Environment=
+func systemdEnvironment(env []EnvVar) []string {
+	var environment []string
+	for _, v := range env {
+		environment = append(environment, systemdQuote(v.Key+"="+v.Value))
+	}
+	return environment
+}
+
+// systemdServiceConfig returns the data of an org's systemd service
+func (e *EnableCommand) systemdServiceConfig(scope systemdScope, exePath, org string, runnerDirs []string) (SystemdServiceConfig, error) {
+	env, err := e.environment()
+	if err != nil {
+		return SystemdServiceConfig{}, err
+	}
+
+	orgDir := filepath.Join(e.RootDir, org)
+
+	// Paths the service may write to with --hardening=strict
+	writable := append([]string{orgDir}, e.ReadWritePaths...)
+	writable = append(writable, e.runnerHomes(org, runnerDirs)...)
+
+	username := e.serviceUsername(org)
+	home := ""
+	if username != "" {
+		home = userHomeDir(username)
+	}
+
+	return SystemdServiceConfig{
+		ServiceName:    fmt.Sprintf("ghrunner-%s", org),
+		Org:            org,
+		OrgDir:         orgDir,
+		RootDir:        e.RootDir,
+		User:           username,
+		Home:           home,
+		ExePath:        exePath,
+		StopTimeout:    e.StopTimeout,
+		TimeoutStopSec: systemdStopTimeout(e.StopTimeout),
+		StopSeconds:    serviceStopSeconds(e.StopTimeout),
+		RunAsOwner:     e.Isolation == "runner",
+		WantedBy:       scope.wantedBy(),
+		Runners:        runnerDirs,
+		Labels:         runnerLabels(e.RootDir, runnerDirs),
+
+		Env:              env,
+		Environment:      systemdEnvironment(env),
+		EnvironmentFiles: e.EnvironmentFiles,
+		EnvFiles:         e.envFiles(),
+		Resources:        e.systemdResources(),
+		Hardening:        scope.hardening(e.Hardening, writable),
+	}, nil
+}
+
+// systemdRunnerConfig returns the data of the ghrunner@ template unit. The
+// instances are shared by every org, so Org and OrgDir are empty and system
+// services run as root, dropping privileges to each runner directory's owner.
+// Resource limits apply to each runner.
+func (e *EnableCommand) systemdRunnerConfig(scope systemdScope, exePath string, orgs map[string][]string, orgNames []string) (SystemdServiceConfig, error) {
+	env, err := e.environment()
+	if err != nil {
+		return SystemdServiceConfig{}, err
+	}
+
+	var runnerDirs []string
+	writable := append([]string{e.RootDir}, e.ReadWritePaths...)
+	for _, org := range orgNames {
+		runnerDirs = append(runnerDirs, orgs[org]...)
+		writable = append(writable, e.runnerHomes(org, orgs[org])...)
+	}
+
+	return SystemdServiceConfig{
+		ServiceName:    strings.TrimSuffix(systemdRunnerUnit, ".service"),
+		RootDir:        e.RootDir,
+		User:           e.serviceUsername(""),
+		ExePath:        exePath,
+		StopTimeout:    e.StopTimeout,
+		TimeoutStopSec: systemdStopTimeout(e.StopTimeout),
+		RunAsOwner:     !e.User,
+		WantedBy:       scope.wantedBy(),
+		Runners:        runnerDirs,
+		Labels:         runnerLabels(e.RootDir, runnerDirs),
+
+		Env:              env,
+		Environment:      systemdEnvironment(env),
+		EnvironmentFiles: e.EnvironmentFiles,
+		Resources:        e.systemdResources(),
+		Hardening:        scope.hardening(e.Hardening, writable),
+	}, nil
+}
+
+// runnerServiceName returns the ghrunner@ instance running a runner
+func runnerServiceName(rootDir, runnerDir string) string {
+	return "ghrunner@" + runnerInstance(rootDir, runnerDir)
+}
+
+func (e *EnableCommand) Run() error {
+	if e.Check || e.Fix {
+		return e.check()
+	}
+
+	manager, err := newServiceManager(e.Init)
+	if err != nil {
+		return err
+	}
+
+	// Record runners set up by hand since the last scan
+	if _, err := scanRunners(e.RootDir); err != nil {
+		return err
+	}
+	if err := manager.enable(e); err != nil {
+		return err
+	}
+	return e.recordOptions()
+}
+
+// recordOptions records the flags of a successful enable in the manifest
+func (e *EnableCommand) recordOptions() error {
+	return updateManifest(e.RootDir, func(m *Manifest) error {
+		m.EnableOptions = e.options()
+		return nil
+	})
+}
+
+func (e *EnableCommand) enableMacOS() error {
+	orgs, _, err := e.orgRunners()
+	if err != nil {
+		return err
+	}
+	var runnerDirs []string
+	for _, dirs := range orgs {
+		runnerDirs = append(runnerDirs, dirs...)
+	}
+	sort.Strings(runnerDirs)
+
+	exePath, err := executablePath()
+	if err != nil {
+		return err
 	}
 
 	// Get LaunchAgents directory
@@ -113,153 +565,336 @@ func (e *EnableCommand) enableMacOS() error {
 		return fmt.Errorf("failed to create LaunchAgents directory: %w", err)
 	}
 
+	config, err := e.launchAgentConfig(exePath, homeDir, runnerDirs)
+	if err != nil {
+		return err
+	}
+
 	// Create log directory
-	logDir := filepath.Join(homeDir, "Library", "Logs", "ghrunner")
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+	if err := os.MkdirAll(config.LogPath, 0755); err != nil {
 		return fmt.Errorf("failed to create log directory: %w", err)
 	}
 
-	tmpl, err := template.New("plist").Parse(launchAgentTemplate)
+	content, err := e.renderLaunchAgent(config)
 	if err != nil {
-		return fmt.Errorf("failed to parse template: %w", err)
+		return err
 	}
 
-	label := "com.github.actions.runner"
-	plistPath := filepath.Join(launchAgentsDir, label+".plist")
-
-	config := LaunchAgentConfig{
-		Label:   label,
-		ExePath: exePath,
-		RootDir: e.RootDir,
-		LogPath: logDir,
+	plistPath := filepath.Join(launchAgentsDir, config.Label+".plist")
+	if err := os.WriteFile(plistPath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write plist file %s: %w", plistPath, err)
 	}
 
-	file, err := os.Create(plistPath)
+	err = updateManifest(e.RootDir, func(m *Manifest) error {
+		m.setServices("launchd", []ManifestService{{
+			Name:        config.Label,
+			Manager:     "launchd",
+			Path:        plistPath,
+			Runners:     e.runnerNames(runnerDirs),
+			InstalledAt: time.Now(),
+		}})
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create plist file %s: %w", plistPath, err)
-	}
-	defer file.Close()
-
-	if err := tmpl.Execute(file, config); err != nil {
-		return fmt.Errorf("failed to write plist file %s: %w", plistPath, err)
+		return err
 	}
 
 	fmt.Printf("Created LaunchAgent: %s\n", plistPath)
 	fmt.Printf("Executable: %s\n", exePath)
-	fmt.Printf("Log files will be at: %s\n", logDir)
+	fmt.Printf("Log files will be at: %s\n", config.LogPath)
 	fmt.Println("\nTo start: launchctl load " + plistPath)
 	fmt.Println("To stop:  launchctl unload " + plistPath)
 	return nil
 }
 
 func (e *EnableCommand) enableLinux() error {
-	// Check if running as root
-	currentUser, err := user.Current()
+	scope := systemdScope{user: e.User}
+	if err := scope.requireRoot("enable"); err != nil {
+		return err
+	}
+	if e.User && e.Isolation == "runner" {
+		return fmt.Errorf("--isolation=runner creates system users and can't be used with --user")
+	}
+
+	orgs, orgNames, err := e.orgRunners()
 	if err != nil {
-		return fmt.Errorf("failed to get current user: %w", err)
+		return err
 	}
-	if currentUser.Uid != "0" {
-		return fmt.Errorf("enable command on Linux requires root privileges. Please run with sudo")
+
+	exePath, err := executablePath()
+	if err != nil {
+		return err
 	}
 
-	runnerDirs, err := searchRunnerDirs(e.RootDir)
+	unitDir, err := scope.unitDir()
 	if err != nil {
-		return fmt.Errorf("failed to search runner dirs: %w", err)
+		return err
+	}
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", unitDir, err)
 	}
 
-	if len(runnerDirs) == 0 {
-		return fmt.Errorf("no runners found in %s", e.RootDir)
+	if !e.User {
+		if err := e.prepareUsers(orgs, orgNames); err != nil {
+			return err
+		}
 	}
 
-	// Get executable path
-	exePath, err := os.Executable()
+	var services []ManifestService
+	if e.Layout == "runner" {
+		services, err = e.enableRunnerServices(scope, unitDir, exePath, orgs, orgNames)
+	} else {
+		services, err = e.enableOrgServices(scope, unitDir, exePath, orgs, orgNames)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
+		return err
 	}
-	exePath, err = filepath.EvalSymlinks(exePath)
+	err = updateManifest(e.RootDir, func(m *Manifest) error {
+		m.setServices(scope.manager(), services)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to resolve executable path: %w", err)
+		return err
 	}
 
-	tmpl, err := template.New("systemd").Parse(systemdServiceTemplate)
-	if err != nil {
-		return fmt.Errorf("failed to parse template: %w", err)
+	fmt.Printf("\nExecutable: %s\n", exePath)
+	if e.Layout == "runner" {
+		fmt.Printf("To start: %s start \"$(systemd-escape --template=%s <org>/<runner>)\"\n", scope.command(), systemdRunnerUnit)
+		fmt.Printf("To stop:  %s stop \"$(systemd-escape --template=%s <org>/<runner>)\"\n", scope.command(), systemdRunnerUnit)
+	} else {
+		fmt.Printf("To start: %s start ghrunner-<org>\n", scope.command())
+		fmt.Printf("To stop:  %s stop ghrunner-<org>\n", scope.command())
 	}
+	scope.checkLinger()
+	return nil
+}
 
-	// Find all unique orgs
-	orgs := make(map[string]bool)
-	for _, runnerDir := range runnerDirs {
-		relPath, err := filepath.Rel(e.RootDir, runnerDir)
-		if err != nil {
-			continue
-		}
-		parts := strings.Split(relPath, string(filepath.Separator))
-		if len(parts) >= 1 {
-			orgs[parts[0]] = true
+// prepareUsers creates the users running the runners of system services,
+// recording which user runs what for disable
+func (e *EnableCommand) prepareUsers(orgs map[string][]string, orgNames []string) error {
+	if _, err := e.orgUsernames(orgNames); err != nil {
+		return err
+	}
+	if err := e.checkRunnerUsernames(orgs, orgNames); err != nil {
+		return err
+	}
+	usernames := make(map[string]string)
+	for _, org := range orgNames {
+		if err := e.prepareOrgUsers(org, orgs[org], usernames); err != nil {
+			return err
 		}
 	}
+	return updateManifest(e.RootDir, func(m *Manifest) error {
+		m.Usernames = usernames
+		return nil
+	})
+}
 
-	for org := range orgs {
-		// Create user for this org if not exists
-		username := org
-		if err := e.createLinuxUser(username); err != nil {
-			return fmt.Errorf("failed to create user %s: %w", username, err)
+// prepareOrgUsers creates the users running an org's runners and hands the
+// runner directories over to them, adding them to usernames by org or runner
+func (e *EnableCommand) prepareOrgUsers(org string, runnerDirs []string, usernames map[string]string) error {
+	orgDir := filepath.Join(e.RootDir, org)
+
+	if e.Isolation == "runner" {
+		// One user per runner, the service runs as root and
+		// start drops privileges to each runner directory's owner
+		for _, runnerDir := range runnerDirs {
+			runnerUser := e.runnerUsername(org, runnerDir)
+			if err := e.createLinuxUser(runnerUser); err != nil {
+				return fmt.Errorf("failed to create user %s: %w", runnerUser, err)
+			}
+			if err := e.chownRecursive(runnerDir, runnerUser); err != nil {
+				return fmt.Errorf("failed to change ownership of %s: %w", runnerDir, err)
+			}
+			// Setup creates runner directories readable by everyone,
+			// keep other runners out of the checkouts in _work
+			if err := os.Chmod(runnerDir, runnerDirMode); err != nil {
+				return fmt.Errorf("failed to change mode of %s: %w", runnerDir, err)
+			}
+			usernames[runnerRelName(e.RootDir, runnerDir)] = runnerUser
 		}
-
-		// Change ownership of org's runner directory to the user
-		orgDir := filepath.Join(e.RootDir, org)
-		if err := e.chownRecursive(orgDir, username); err != nil {
+		// Keep runner users from touching each other's directories
+		if err := os.Chown(orgDir, 0, 0); err != nil {
 			return fmt.Errorf("failed to change ownership of %s: %w", orgDir, err)
 		}
+		return nil
+	}
 
-		serviceName := fmt.Sprintf("ghrunner-%s", org)
-		servicePath := filepath.Join("/etc/systemd/system", serviceName+".service")
+	// Create user for this org if not exists
+	orgUser := e.orgUsername(org)
+	if err := e.createLinuxUser(orgUser); err != nil {
+		return fmt.Errorf("failed to create user %s: %w", orgUser, err)
+	}
 
-		config := SystemdServiceConfig{
-			Org:     org,
-			OrgDir:  orgDir,
-			User:    username,
-			ExePath: exePath,
-		}
+	// Change ownership of org's runner directory to the user
+	if err := e.chownRecursive(orgDir, orgUser); err != nil {
+		return fmt.Errorf("failed to change ownership of %s: %w", orgDir, err)
+	}
+	usernames[org] = orgUser
+	return nil
+}
+
+// enableOrgServices installs one service per org, replacing per-runner
+// services, and returns the installed services
+func (e *EnableCommand) enableOrgServices(scope systemdScope, unitDir, exePath string, orgs map[string][]string, orgNames []string) ([]ManifestService, error) {
+	// Runners must not be run by both layouts at once
+	for _, serviceName := range scope.staleInstances(unitDir, nil) {
+		scope.disableInstance(serviceName)
+	}
+	if _, err := os.Stat(filepath.Join(unitDir, systemdRunnerUnit)); err == nil {
+		scope.removeService(unitDir, strings.TrimSuffix(systemdRunnerUnit, ".service"))
+	}
 
-		file, err := os.Create(servicePath)
+	var services []ManifestService
+	for _, org := range orgNames {
+		config, err := e.systemdServiceConfig(scope, exePath, org, orgs[org])
+		if err != nil {
+			return nil, err
+		}
+		content, err := e.renderSystemdService(scope, config)
 		if err != nil {
-			return fmt.Errorf("failed to create service file %s: %w", servicePath, err)
+			return nil, err
 		}
 
-		if err := tmpl.Execute(file, config); err != nil {
-			file.Close()
-			return fmt.Errorf("failed to write service file %s: %w", servicePath, err)
+		serviceName := config.ServiceName
+		servicePath := filepath.Join(unitDir, serviceName+".service")
+		if err := os.WriteFile(servicePath, []byte(content), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write service file %s: %w", servicePath, err)
 		}
-		file.Close()
 
 		// Enable the service
-		cmd := exec.Command("systemctl", "enable", serviceName)
+		cmd := scope.systemctl("enable", serviceName)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to enable service %s: %w", serviceName, err)
+			return nil, fmt.Errorf("failed to enable service %s: %w", serviceName, err)
+		}
+		services = append(services, ManifestService{
+			Name:        serviceName,
+			Manager:     scope.manager(),
+			Path:        servicePath,
+			User:        config.User,
+			Runners:     e.runnerNames(config.Runners),
+			InstalledAt: time.Now(),
+		})
+
+		if e.User {
+			fmt.Printf("Created and enabled systemd user service: %s\n", serviceName)
+		} else {
+			fmt.Printf("Created and enabled systemd service: %s (user: %s)\n", serviceName, config.User)
 		}
-
-		fmt.Printf("Created and enabled systemd service: %s (user: %s)\n", serviceName, username)
 	}
 
 	// Reload systemd
-	cmd := exec.Command("systemctl", "daemon-reload")
+	cmd := scope.systemctl("daemon-reload")
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to reload systemd: %w", err)
+		return nil, fmt.Errorf("failed to reload systemd: %w", err)
 	}
+	return services, nil
+}
 
-	fmt.Printf("\nExecutable: %s\n", exePath)
-	fmt.Println("To start: sudo systemctl start ghrunner-<org>")
-	fmt.Println("To stop:  sudo systemctl stop ghrunner-<org>")
-	return nil
+// enableRunnerServices installs the ghrunner@ template unit and enables an
+// instance per runner, replacing per-org services, and returns the
+// installed services
+func (e *EnableCommand) enableRunnerServices(scope systemdScope, unitDir, exePath string, orgs map[string][]string, orgNames []string) ([]ManifestService, error) {
+	// Runners must not be run by both layouts at once
+	for _, org := range orgNames {
+		serviceName := fmt.Sprintf("ghrunner-%s", org)
+		if _, err := os.Stat(filepath.Join(unitDir, serviceName+".service")); err == nil {
+			scope.removeService(unitDir, serviceName)
+		}
+	}
+
+	config, err := e.systemdRunnerConfig(scope, exePath, orgs, orgNames)
+	if err != nil {
+		return nil, err
+	}
+	content, err := e.renderSystemdService(scope, config)
+	if err != nil {
+		return nil, err
+	}
+
+	unitPath := filepath.Join(unitDir, systemdRunnerUnit)
+	if err := os.WriteFile(unitPath, []byte(content), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write service file %s: %w", unitPath, err)
+	}
+	fmt.Printf("Created systemd template unit: %s\n", unitPath)
+
+	// Reload systemd so the instances can be enabled
+	cmd := scope.systemctl("daemon-reload")
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to reload systemd: %w", err)
+	}
+
+	var instances []string
+	for _, runnerDir := range config.Runners {
+		instances = append(instances, runnerServiceName(e.RootDir, runnerDir))
+	}
+	for _, serviceName := range scope.staleInstances(unitDir, instances) {
+		scope.disableInstance(serviceName)
+	}
+
+	var services []ManifestService
+	for _, runnerDir := range config.Runners {
+		serviceName := runnerServiceName(e.RootDir, runnerDir)
+		cmd := scope.systemctl("enable", serviceName)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("failed to enable service %s: %w", serviceName, err)
+		}
+		fmt.Printf("Enabled systemd service: %s\n", serviceName)
+		services = append(services, ManifestService{
+			Name:        serviceName,
+			Manager:     scope.manager(),
+			User:        config.User,
+			Runners:     e.runnerNames([]string{runnerDir}),
+			InstalledAt: time.Now(),
+		})
+	}
+
+	// The template unit goes last so disable removes it after its instances
+	services = append(services, ManifestService{
+		Name:        config.ServiceName,
+		Manager:     scope.manager(),
+		Path:        unitPath,
+		InstalledAt: time.Now(),
+	})
+	return services, nil
+}
+
+// runnerNames returns the <org>/<name> of runner directories for the manifest
+func (e *EnableCommand) runnerNames(runnerDirs []string) []string {
+	var names []string
+	for _, runnerDir := range runnerDirs {
+		names = append(names, runnerRelName(e.RootDir, runnerDir))
+	}
+	return names
+}
+
+// userHomeDir returns the home directory of a user, or "" if it can't be looked up
+func userHomeDir(username string) string {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return ""
+	}
+	return u.HomeDir
 }
 
 func (e *EnableCommand) createLinuxUser(username string) error {
 	// Check if user already exists
-	_, err := user.Lookup(username)
-	if err == nil {
+	if u, err := user.Lookup(username); err == nil {
+		var created []string
+		m, err := loadManifest(e.RootDir)
+		if err != nil {
+			return err
+		}
+		if m != nil {
+			created = m.CreatedUsers
+		}
+		if err := e.checkAdoptable(u, created); err != nil {
+			return err
+		}
 		fmt.Printf("User %s already exists\n", username)
 		return nil
 	}
@@ -273,6 +908,11 @@ func (e *EnableCommand) createLinuxUser(username string) error {
 	}
 
 	fmt.Printf("Created system user: %s\n", username)
+
+	// Remember the user so disable --purge-users only deletes users ghrunner created
+	if err := recordCreatedUser(e.RootDir, username); err != nil {
+		return fmt.Errorf("failed to record user %s: %w", username, err)
+	}
 	return nil
 }
 
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// runnerEnvFileName is the dotenv file ghrunner merges into a runner's
+// environment, looked up in the root directory, the runner's org directory
+// and the runner directory, later files overriding earlier ones
+const runnerEnvFileName = "ghrunner.env"
+
+// runnerEnv returns the environment configured for a runner and the values
+// that must be masked in logs
+func (s *StartCommand) runnerEnv(dir string) (map[string]string, []string, error) {
+	values := make(map[string]string)
+
+	// Explicit files must exist, the ones found next to the runner are optional.
+	// The root and org directories are the same when --root-dir is an org directory.
+	optional := []string{
+		filepath.Join(s.RootDir, runnerEnvFileName),
+		filepath.Join(filepath.Dir(dir), runnerEnvFileName),
+		filepath.Join(dir, runnerEnvFileName),
+	}
+	seen := make(map[string]bool)
+	for i, path := range append(append([]string{}, s.EnvFiles...), optional...) {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		fileValues, err := parseDotEnv(path)
+		if os.IsNotExist(err) && i >= len(s.EnvFiles) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read env file: %w", err)
+		}
+		for key, value := range fileValues {
+			values[key] = value
+		}
+	}
+
+	var secrets []string
+	if s.SecretsDir != "" {
+		entries, err := os.ReadDir(s.SecretsDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read secrets dir: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			if !envKey.MatchString(entry.Name()) {
+				return nil, nil, fmt.Errorf("secret file name %q is not a valid environment variable name", entry.Name())
+			}
+			data, err := os.ReadFile(filepath.Join(s.SecretsDir, entry.Name()))
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read secret %s: %w", entry.Name(), err)
+			}
+			value := strings.TrimRight(string(data), "\r\n")
+			values[entry.Name()] = value
+			secrets = append(secrets, value)
+		}
+	}
+
+	return values, secrets, nil
+}
+
+// envList formats values as KEY=VALUE pairs in a stable order
+func envList(values map[string]string) []string {
+	var result []string
+	for key, value := range values {
+		result = append(result, key+"="+value)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// secretMasker replaces secret values in log output
+type secretMasker struct {
+	mu       sync.RWMutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+// add registers secret values to be masked
+func (m *secretMasker) add(secrets []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.secrets == nil {
+		m.secrets = make(map[string]bool)
+	}
+
+	changed := false
+	for _, secret := range secrets {
+		// Masking very short values would garble unrelated output
+		if len(secret) < 4 || m.secrets[secret] {
+			continue
+		}
+		m.secrets[secret] = true
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	// Longest first so a secret containing another one is masked as a whole
+	var sorted []string
+	for secret := range m.secrets {
+		sorted = append(sorted, secret)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	var pairs []string
+	for _, secret := range sorted {
+		pairs = append(pairs, secret, "***")
+	}
+	m.replacer = strings.NewReplacer(pairs...)
+}
+
+func (m *secretMasker) mask(s string) string {
+	if m == nil {
+		return s
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.replacer == nil {
+		return s
+	}
+	return m.replacer.Replace(s)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// holds keeps the reasons for which no runner may take new jobs, such as a
+// full disk. Runners wait at the top of their loop while any hold is set.
+type holds struct {
+	mu      sync.Mutex
+	reasons map[string]string
+	changed chan struct{}
+}
+
+func newHolds() *holds {
+	return &holds{
+		reasons: make(map[string]string),
+		changed: make(chan struct{}),
+	}
+}
+
+// set adds or updates a hold, returning true if it wasn't set before
+func (h *holds) set(key, message string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, existed := h.reasons[key]
+	if existed && h.reasons[key] == message {
+		return false
+	}
+	h.reasons[key] = message
+	h.notify()
+	return !existed
+}
+
+// clear removes a hold, returning true if it was set
+func (h *holds) clear(key string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.reasons[key]; !ok {
+		return false
+	}
+	delete(h.reasons, key)
+	h.notify()
+	return true
+}
+
+// notify wakes up everyone waiting on the current changed channel; h.mu must be held
+func (h *holds) notify() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// active returns the current hold messages and a channel closed on the next change
+func (h *holds) active() ([]string, <-chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var messages []string
+	for _, message := range h.reasons {
+		messages = append(messages, message)
+	}
+	sort.Strings(messages)
+	return messages, h.changed
+}
+
+// String joins the current hold messages
+func (h *holds) String() string {
+	messages, _ := h.active()
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// shutdownHookTimeout caps the post-run hook while shutting down, so it
+// ends within the stop time enable gives the service
+const shutdownHookTimeout = 30 * time.Second
+
+// runHook runs a pre-run or post-run hook command in the runner directory
+func (s *StartCommand) runHook(ctx context.Context, name, command string, r *runner, extraEnv ...string) error {
+	if command == "" {
+		return nil
+	}
+
+	fmt.Printf("Running %s hook for runner: %s\n", name, r.dir)
+	env := []string{
+		"GHRUNNER_RUNNER_DIR=" + r.dir,
+		"GHRUNNER_RUNNER_NAME=" + filepath.Base(r.dir),
+		"GHRUNNER_WORK_DIR=" + filepath.Join(r.dir, "_work"),
+	}
+	return s.runCommandHook(ctx, name, command, r.dir, append(env, extraEnv...)...)
+}
+
+// runCommandHook runs a pre-run or post-run hook. The hook's process
+// group is killed when it exceeds HookTimeout, or the earlier deadline of ctx.
+func (s *StartCommand) runCommandHook(ctx context.Context, name, command, dir string, extraEnv ...string) error {
+	if command == "" {
+		return nil
+	}
+
+	timeout := s.HookTimeout
+	if deadline, ok := ctx.Deadline(); ok && (timeout <= 0 || time.Until(deadline) < timeout) {
+		timeout = time.Until(deadline)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := s.runCommand(ctx, name, command, dir, extraEnv...); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s hook timed out after %s", name, timeout.Round(time.Second))
+		}
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	return nil
+}
+
+// runCommand runs a configured command through /bin/sh in dir, killing its
+// process group when ctx is done. With --run-as-owner it runs as the owner
+// of dir, like the runners.
+func (s *StartCommand) runCommand(ctx context.Context, name, command, dir string, extraEnv ...string) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "GHRUNNER_HOOK="+name, "GHRUNNER_ROOT_DIR="+s.RootDir)
+	cmd.Env = append(cmd.Env, extraEnv...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if s.RunAsOwner {
+		cred, env, err := runnerCredential(dir)
+		if err != nil {
+			return fmt.Errorf("failed to determine the user of %s: %w", dir, err)
+		}
+		if cred != nil {
+			cmd.SysProcAttr.Credential = cred
+			cmd.Env = append(cmd.Env, env...)
+		}
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	return cmd.Run()
+}
+
+// jobHookEnv returns the .env entries that make the runner itself call the
+// job started/completed hooks around every job
+func (s *StartCommand) jobHookEnv() map[string]string {
+	values := make(map[string]string)
+	if s.JobStartedHook != "" {
+		values["ACTIONS_RUNNER_HOOK_JOB_STARTED"] = s.JobStartedHook
+	}
+	if s.JobCompletedHook != "" {
+		values["ACTIONS_RUNNER_HOOK_JOB_COMPLETED"] = s.JobCompletedHook
+	}
+	return values
+}
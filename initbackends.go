@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// OpenRC init script running the org with supervise-daemon
+const openrcServiceTemplate = `#!/sbin/openrc-run
+
+description="GitHub Actions Runner - {{.Org}}"
+supervisor=supervise-daemon
+command={{shquote .ExePath}}
+command_args="start --root-dir={{shquote .OrgDir}} --stop-timeout={{.StopTimeout}}{{if .RunAsOwner}} --run-as-owner{{end}}"
+{{- if .User}}
+command_user={{shquote .User}}
+{{- end}}
+respawn_delay=5
+respawn_max=0
+retry="TERM/{{if .StopSeconds}}{{.StopSeconds}}{{else}}31536000{{end}}/KILL/5"
+output_log=/var/log/{{.ServiceName}}.log
+error_log=/var/log/{{.ServiceName}}.log
+{{- if .Home}}
+export HOME={{shquote .Home}}
+{{- end}}
+{{- range .Env}}
+export {{.Key}}={{shquote .Value}}
+{{- end}}
+{{- range .EnvFiles}}
+{{- if .Optional}}
+[ -r {{shquote .Path}} ] && set -a && . {{shquote .Path}} && set +a
+{{- else}}
+set -a; . {{shquote .Path}}; set +a
+{{- end}}
+{{- end}}
+
+extra_started_commands="reload"
+
+depend() {
+	need net
+}
+
+reload() {
+	ebegin "Reloading ${RC_SVCNAME}"
+	supervise-daemon "${RC_SVCNAME}" --signal HUP
+	eend $?
+}
+`
+
+// runit run script of the org, logging to runsvdir
+const runitServiceTemplate = `#!/bin/sh
+exec 2>&1
+{{- if .Home}}
+export HOME={{shquote .Home}}
+{{- end}}
+{{- range .Env}}
+export {{.Key}}={{shquote .Value}}
+{{- end}}
+{{- range .EnvFiles}}
+{{- if .Optional}}
+if [ -r {{shquote .Path}} ]; then set -a; . {{shquote .Path}}; set +a; fi
+{{- else}}
+set -a; . {{shquote .Path}} || exit 1; set +a
+{{- end}}
+{{- end}}
+exec {{if .User}}chpst -u {{shquote .User}} {{end}}{{shquote .ExePath}} start --root-dir={{shquote .OrgDir}} --stop-timeout={{.StopTimeout}}{{if .RunAsOwner}} --run-as-owner{{end}}
+`
+
+// supervisord program section of the org
+const supervisordServiceTemplate = `[program:{{.ServiceName}}]
+command={{supervisordQuote .ExePath}} start --root-dir={{supervisordQuote .OrgDir}} --stop-timeout={{.StopTimeout}}{{if .RunAsOwner}} --run-as-owner{{end}}
+{{- if .User}}
+user={{.User}}
+{{- end}}
+{{- with supervisordEnv .Home .Env}}
+environment={{.}}
+{{- end}}
+autostart=true
+autorestart=true
+startsecs=5
+stopsignal=TERM
+stopwaitsecs={{if .StopSeconds}}{{.StopSeconds}}{{else}}31536000{{end}}
+killasgroup=false
+redirect_stderr=true
+`
+
+// supervisordQuote quotes a command= argument for supervisord, which splits
+// the command like a shell and expands % first
+func supervisordQuote(s string) string {
+	return strings.ReplaceAll(shellQuote(s), "%", "%%")
+}
+
+// supervisordEnvironment formats HOME and the --environment variables for
+// environment=, escaping quotes and supervisord's % expansions
+func supervisordEnvironment(home string, env []EnvVar) string {
+	var pairs []string
+	quote := func(s string) string {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+		s = strings.ReplaceAll(s, `"`, `\"`)
+		s = strings.ReplaceAll(s, "%", "%%")
+		return `"` + s + `"`
+	}
+	if home != "" {
+		pairs = append(pairs, "HOME="+quote(home))
+	}
+	for _, v := range env {
+		pairs = append(pairs, v.Key+"="+quote(v.Value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// openrcBackend installs init scripts in /etc/init.d, added to the default runlevel
+type openrcBackend struct {
+	root string // prefixed to the system paths, for tests
+}
+
+func (openrcBackend) name() string           { return "openrc" }
+func (openrcBackend) template() string       { return openrcServiceTemplate }
+func (openrcBackend) fileMode() os.FileMode  { return 0755 }
+func (openrcBackend) environmentFiles() bool { return true }
+
+func (b openrcBackend) servicePath(name string) string {
+	return filepath.Join(b.root, "/etc/init.d", name)
+}
+
+func (openrcBackend) activate(name string) error {
+	return runInitTool("rc-update", "add", name, "default")
+}
+
+func (b openrcBackend) activated(name string) bool {
+	return pathExists(filepath.Join(b.root, "/etc/runlevels/default", name))
+}
+
+func (openrcBackend) stopService(name string) error {
+	return runInitTool("rc-service", name, "stop")
+}
+
+func (b openrcBackend) remove(name string) error {
+	// Stopping fails when the service isn't running
+	b.stopService(name)
+	if b.activated(name) {
+		if err := runInitTool("rc-update", "del", name, "default"); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(b.servicePath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (openrcBackend) commands() (string, string) {
+	return "sudo rc-service ghrunner-<org> start", "sudo rc-service ghrunner-<org> stop"
+}
+
+// runitBackend installs service directories in /etc/sv, linked into the
+// directory runsvdir scans, which starts them right away
+type runitBackend struct {
+	root string // prefixed to the system paths, for tests
+}
+
+func (runitBackend) name() string           { return "runit" }
+func (runitBackend) template() string       { return runitServiceTemplate }
+func (runitBackend) fileMode() os.FileMode  { return 0755 }
+func (runitBackend) environmentFiles() bool { return true }
+
+func (b runitBackend) servicePath(name string) string {
+	return filepath.Join(b.root, "/etc/sv", name, "run")
+}
+
+// serviceDir returns the directory runsvdir scans: $SVDIR, or the first
+// existing of the locations distributions use
+func (b runitBackend) serviceDir() string {
+	if dir := os.Getenv("SVDIR"); dir != "" {
+		return dir
+	}
+	for _, dir := range []string{"/var/service", "/etc/service", "/run/runit/service"} {
+		if pathExists(filepath.Join(b.root, dir)) {
+			return filepath.Join(b.root, dir)
+		}
+	}
+	return filepath.Join(b.root, "/etc/service")
+}
+
+func (b runitBackend) activate(name string) error {
+	link := filepath.Join(b.serviceDir(), name)
+	if b.activated(name) {
+		return nil
+	}
+	return os.Symlink(filepath.Dir(b.servicePath(name)), link)
+}
+
+func (b runitBackend) activated(name string) bool {
+	target, err := os.Readlink(filepath.Join(b.serviceDir(), name))
+	return err == nil && target == filepath.Dir(b.servicePath(name))
+}
+
+func (b runitBackend) stopService(name string) error {
+	return runInitTool("sv", "stop", filepath.Join(b.serviceDir(), name))
+}
+
+func (b runitBackend) remove(name string) error {
+	link := filepath.Join(b.serviceDir(), name)
+	if _, err := os.Lstat(link); err == nil {
+		// Stopping fails when runsv isn't supervising the service
+		b.stopService(name)
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(filepath.Dir(b.servicePath(name)))
+}
+
+func (b runitBackend) commands() (string, string) {
+	dir := b.serviceDir()
+	return fmt.Sprintf("sudo sv start %s/ghrunner-<org>", dir), fmt.Sprintf("sudo sv stop %s/ghrunner-<org>", dir)
+}
+
+// supervisordBackend installs program sections in supervisord's include
+// directory, started right away by supervisorctl update
+type supervisordBackend struct {
+	root string // prefixed to the system paths, for tests
+}
+
+func (supervisordBackend) name() string           { return "supervisord" }
+func (supervisordBackend) template() string       { return supervisordServiceTemplate }
+func (supervisordBackend) fileMode() os.FileMode  { return 0644 }
+func (supervisordBackend) environmentFiles() bool { return false }
+
+// servicePath returns the file in the include directory of the
+// distribution: conf.d on Debian, supervisord.d on Fedora and Alpine
+func (b supervisordBackend) servicePath(name string) string {
+	for _, dir := range []struct{ path, ext string }{
+		{"/etc/supervisor/conf.d", ".conf"},
+		{"/etc/supervisor.d", ".ini"},
+		{"/etc/supervisord.d", ".ini"},
+	} {
+		if pathExists(filepath.Join(b.root, dir.path)) {
+			return filepath.Join(b.root, dir.path, name+dir.ext)
+		}
+	}
+	return filepath.Join(b.root, "/etc/supervisor/conf.d", name+".conf")
+}
+
+func (supervisordBackend) activate(name string) error {
+	if err := runInitTool("supervisorctl", "reread"); err != nil {
+		return err
+	}
+	return runInitTool("supervisorctl", "update", name)
+}
+
+func (supervisordBackend) activated(name string) bool {
+	out, err := exec.Command("supervisorctl", "avail").Output()
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (supervisordBackend) stopService(name string) error {
+	return runInitTool("supervisorctl", "stop", name)
+}
+
+func (b supervisordBackend) remove(name string) error {
+	// Stopping fails when supervisord doesn't know the program
+	b.stopService(name)
+	if err := os.Remove(b.servicePath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := runInitTool("supervisorctl", "reread"); err != nil {
+		return err
+	}
+	return runInitTool("supervisorctl", "update", name)
+}
+
+func (supervisordBackend) commands() (string, string) {
+	return "sudo supervisorctl start ghrunner-<org>", "sudo supervisorctl stop ghrunner-<org>"
+}
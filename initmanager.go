@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// initBackend is a service manager running a service per org from a file
+// ghrunner writes: OpenRC, runit or supervisord
+type initBackend interface {
+	name() string
+	template() string
+	servicePath(serviceName string) string
+	fileMode() os.FileMode
+	// environmentFiles reports whether services can load --environment-file
+	environmentFiles() bool
+	// activate registers a written service so it runs at boot
+	activate(serviceName string) error
+	activated(serviceName string) bool
+	stopService(serviceName string) error
+	// remove stops, unregisters and deletes a service
+	remove(serviceName string) error
+	// commands returns how users start and stop a service, for hints
+	commands() (start, stop string)
+}
+
+// initManager installs a service per org with an initBackend. The
+// systemd-only features, --user, --layout=runner, the service resource
+// limits and --hardening=strict, aren't available.
+type initManager struct {
+	backend initBackend
+}
+
+// envKey matches variable names shell scripts can export
+var envKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (m initManager) validate(e *EnableCommand) error {
+	switch {
+	case e.User:
+		return fmt.Errorf("--user is only supported with systemd")
+	case e.Layout == "runner":
+		return fmt.Errorf("--layout=runner is only supported with systemd")
+	case e.ServiceMemoryMax > 0 || e.ServiceCPUQuota > 0 || e.ServiceTasksMax > 0:
+		return fmt.Errorf("--service-memory-max, --service-cpu-quota and --service-tasks-max are only supported with systemd")
+	case e.Hardening == "strict":
+		return fmt.Errorf("--hardening=strict is only supported with systemd")
+	case len(e.EnvironmentFiles) > 0 && !m.backend.environmentFiles():
+		return fmt.Errorf("--environment-file is not supported with %s", m.backend.name())
+	}
+	env, err := e.environment()
+	if err != nil {
+		return err
+	}
+	for _, v := range env {
+		if !envKey.MatchString(v.Key) {
+			return fmt.Errorf("invalid --environment variable name %q", v.Key)
+		}
+	}
+	return nil
+}
+
+// renderService renders the service of an org
+func (m initManager) renderService(e *EnableCommand, exePath, org string, runnerDirs []string) (SystemdServiceConfig, string, error) {
+	config, err := e.systemdServiceConfig(systemdScope{}, exePath, org, runnerDirs)
+	if err != nil {
+		return config, "", err
+	}
+	content, err := e.renderTemplate(m.backend.name(), m.backend.template(), config)
+	return config, content, err
+}
+
+func (m initManager) enable(e *EnableCommand) error {
+	if err := m.validate(e); err != nil {
+		return err
+	}
+	if err := (systemdScope{}).requireRoot("enable"); err != nil {
+		return err
+	}
+
+	orgs, orgNames, err := e.orgRunners()
+	if err != nil {
+		return err
+	}
+	exePath, err := executablePath()
+	if err != nil {
+		return err
+	}
+	if err := e.prepareUsers(orgs, orgNames); err != nil {
+		return err
+	}
+
+	var services []ManifestService
+	for _, org := range orgNames {
+		config, content, err := m.renderService(e, exePath, org, orgs[org])
+		if err != nil {
+			return err
+		}
+
+		serviceName := config.ServiceName
+		servicePath := m.backend.servicePath(serviceName)
+		if err := os.MkdirAll(filepath.Dir(servicePath), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(servicePath), err)
+		}
+		if err := os.WriteFile(servicePath, []byte(content), m.backend.fileMode()); err != nil {
+			return fmt.Errorf("failed to write service file %s: %w", servicePath, err)
+		}
+		// WriteFile keeps the mode of an existing file
+		if err := os.Chmod(servicePath, m.backend.fileMode()); err != nil {
+			return err
+		}
+
+		if err := m.backend.activate(serviceName); err != nil {
+			return fmt.Errorf("failed to enable service %s: %w", serviceName, err)
+		}
+		fmt.Printf("Created and enabled %s service: %s (user: %s)\n", m.backend.name(), serviceName, config.User)
+
+		services = append(services, ManifestService{
+			Name:        serviceName,
+			Manager:     m.backend.name(),
+			Path:        servicePath,
+			User:        config.User,
+			Runners:     e.runnerNames(config.Runners),
+			InstalledAt: time.Now(),
+		})
+	}
+
+	err = updateManifest(e.RootDir, func(manifest *Manifest) error {
+		manifest.setServices(m.backend.name(), services)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	start, stop := m.backend.commands()
+	fmt.Printf("\nExecutable: %s\n", exePath)
+	fmt.Printf("To start: %s\n", start)
+	fmt.Printf("To stop:  %s\n", stop)
+	return nil
+}
+
+func (m initManager) check(e *EnableCommand, report *driftReport) error {
+	if err := m.validate(e); err != nil {
+		return err
+	}
+	orgs, orgNames, err := e.orgRunners()
+	if err != nil {
+		return err
+	}
+	exePath, err := executablePath()
+	if err != nil {
+		return err
+	}
+	if err := e.checkUsers(report, orgs, orgNames); err != nil {
+		return err
+	}
+
+	for _, org := range orgNames {
+		config, content, err := m.renderService(e, exePath, org, orgs[org])
+		if err != nil {
+			return err
+		}
+		report.file(m.backend.servicePath(config.ServiceName), content)
+		if !m.backend.activated(config.ServiceName) {
+			report.add("%s is not enabled", config.ServiceName)
+		}
+	}
+	return nil
+}
+
+func (m initManager) render(r *RenderCommand, exePath string, orgs map[string][]string, orgNames []string) error {
+	if err := m.validate(&r.EnableCommand); err != nil {
+		return err
+	}
+	orgNames, err := r.selectedOrgs(orgs, orgNames)
+	if err != nil {
+		return err
+	}
+	for i, org := range orgNames {
+		config, content, err := m.renderService(&r.EnableCommand, exePath, org, orgs[org])
+		if err != nil {
+			return err
+		}
+		printRendered(i, m.backend.servicePath(config.ServiceName), content)
+	}
+	return nil
+}
+
+// installedServices returns the services enable recorded in the manifest,
+// or for root directories enabled before there was a manifest, the org
+// services whose files exist
+func (m initManager) installedServices(rootDir string) ([]string, error) {
+	manifest, err := loadManifest(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if manifest != nil {
+		for _, service := range manifest.services(m.backend.name()) {
+			names = append(names, service.Name)
+		}
+		if len(names) > 0 {
+			return names, nil
+		}
+	}
+
+	runnerDirs, err := searchRunnerDirs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search runner dirs: %w", err)
+	}
+	for _, runnerDir := range runnerDirs {
+		org, _, _ := strings.Cut(runnerRelName(rootDir, runnerDir), "/")
+		name := "ghrunner-" + org
+		if !pathExists(m.backend.servicePath(name)) || slices.Contains(names, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (m initManager) disable(d *DisableCommand) error {
+	if d.User {
+		return fmt.Errorf("--user is only supported with systemd")
+	}
+	if err := (systemdScope{}).requireRoot("disable"); err != nil {
+		return err
+	}
+
+	services, err := m.installedServices(d.RootDir)
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 && !d.PurgeUsers && !d.RestoreOwnership {
+		fmt.Println("No services found, nothing to disable")
+		return nil
+	}
+
+	for _, serviceName := range services {
+		if err := m.backend.remove(serviceName); err != nil {
+			fmt.Printf("Warning: failed to remove %s: %v\n", serviceName, err)
+			continue
+		}
+		fmt.Printf("Removed %s service: %s\n", m.backend.name(), serviceName)
+	}
+
+	err = updateManifest(d.RootDir, func(manifest *Manifest) error {
+		manifest.setServices(m.backend.name(), nil)
+		manifest.EnableOptions = nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return d.removeUsers()
+}
+
+func (m initManager) stop(s *StopCommand) error {
+	if s.User {
+		return fmt.Errorf("--user is only supported with systemd")
+	}
+	if err := (systemdScope{}).requireRoot("stop"); err != nil {
+		return err
+	}
+
+	services, err := m.installedServices(s.RootDir)
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		fmt.Println("No services found")
+		return nil
+	}
+
+	stopped := 0
+	for _, serviceName := range services {
+		if err := m.backend.stopService(serviceName); err != nil {
+			// Might not be running, that's fine
+			continue
+		}
+		fmt.Printf("Stopped service: %s\n", serviceName)
+		stopped++
+	}
+
+	fmt.Printf("\nStopped %d services.\n", stopped)
+	return nil
+}
+
+// runInitTool runs a service manager command, showing its output
+func runInitTool(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeInitTools puts scripts named tools first in PATH, each logging its
+// name and arguments, and returns the log
+func fakeInitTools(t *testing.T, tools ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls.log")
+	for _, tool := range tools {
+		script := "#!/bin/sh\necho \"" + tool + " $*\" >> " + shellQuote(log) + "\n"
+		if err := os.WriteFile(filepath.Join(dir, tool), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+// readCalls returns the logged tool calls and clears the log
+func readCalls(t *testing.T, log string) []string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(log)
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestInitManager(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("enable, stop and disable require root")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("no user nobody to run the runners as")
+	}
+
+	tests := []struct {
+		name    string
+		backend func(root string) initBackend
+		tools   []string
+		// setup prepares the system root like the service manager would
+		setup       func(t *testing.T, root string)
+		wantPath    string
+		wantContent string
+		wantEnable  []string
+		wantStop    []string
+		wantDisable []string
+	}{
+		{
+			name:        "openrc",
+			backend:     func(root string) initBackend { return openrcBackend{root: root} },
+			tools:       []string{"rc-update", "rc-service"},
+			wantPath:    "etc/init.d/ghrunner-org",
+			wantContent: "command_user='nobody'",
+			wantEnable:  []string{"rc-update add ghrunner-org default"},
+			wantStop:    []string{"rc-service ghrunner-org stop"},
+			wantDisable: []string{"rc-service ghrunner-org stop", "rc-update del ghrunner-org default"},
+		},
+		{
+			name:    "runit",
+			backend: func(root string) initBackend { return runitBackend{root: root} },
+			tools:   []string{"sv"},
+			setup: func(t *testing.T, root string) {
+				t.Setenv("SVDIR", filepath.Join(root, "service"))
+				if err := os.MkdirAll(filepath.Join(root, "service"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantPath:    "etc/sv/ghrunner-org/run",
+			wantContent: "chpst -u 'nobody'",
+			wantEnable:  nil,
+			wantStop:    []string{"sv stop {root}/service/ghrunner-org"},
+			wantDisable: []string{"sv stop {root}/service/ghrunner-org"},
+		},
+		{
+			name:    "supervisord",
+			backend: func(root string) initBackend { return supervisordBackend{root: root} },
+			tools:   []string{"supervisorctl"},
+			setup: func(t *testing.T, root string) {
+				if err := os.MkdirAll(filepath.Join(root, "etc/supervisord.d"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantPath:    "etc/supervisord.d/ghrunner-org.ini",
+			wantContent: "start --root-dir='{rootDir}/org'",
+			wantEnable:  []string{"supervisorctl reread", "supervisorctl update ghrunner-org"},
+			wantStop:    []string{"supervisorctl stop ghrunner-org"},
+			wantDisable: []string{"supervisorctl stop ghrunner-org", "supervisorctl reread", "supervisorctl update ghrunner-org"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := fakeInitTools(t, tt.tools...)
+			root := t.TempDir()
+			if tt.setup != nil {
+				tt.setup(t, root)
+			}
+			// A % in the root directory must survive supervisord's expansion
+			rootDir := filepath.Join(t.TempDir(), "runners 100%")
+			runnerDir := filepath.Join(rootDir, "org", "r1")
+			if err := os.MkdirAll(runnerDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(runnerDir, "run.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			replacer := strings.NewReplacer("{root}", root, "{rootDir}", strings.ReplaceAll(rootDir, "%", "%%"))
+			expand := func(calls []string) []string {
+				var expanded []string
+				for _, call := range calls {
+					expanded = append(expanded, replacer.Replace(call))
+				}
+				return expanded
+			}
+
+			m := initManager{backend: tt.backend(root)}
+			e := &EnableCommand{
+				RootDir:     rootDir,
+				StopTimeout: 30 * time.Second,
+				Isolation:   "org",
+				Layout:      "org",
+				Hardening:   "compatible",
+				Usernames:   map[string]string{"org": "nobody"},
+				AdoptUsers:  true,
+			}
+			if err := m.enable(e); err != nil {
+				t.Fatalf("enable: %v", err)
+			}
+			if got := readCalls(t, log); !slices.Equal(got, expand(tt.wantEnable)) {
+				t.Errorf("enable ran %v, want %v", got, expand(tt.wantEnable))
+			}
+			servicePath := filepath.Join(root, tt.wantPath)
+			content, err := os.ReadFile(servicePath)
+			if err != nil {
+				t.Fatalf("service file: %v", err)
+			}
+			if want := replacer.Replace(tt.wantContent); !strings.Contains(string(content), want) {
+				t.Errorf("service file %s doesn't contain %q:\n%s", servicePath, want, content)
+			}
+
+			// OpenRC only deletes services added to a runlevel
+			if tt.name == "openrc" {
+				runlevel := filepath.Join(root, "etc/runlevels/default")
+				if err := os.MkdirAll(runlevel, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(servicePath, filepath.Join(runlevel, "ghrunner-org")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := m.stop(&StopCommand{RootDir: rootDir}); err != nil {
+				t.Fatalf("stop: %v", err)
+			}
+			if got := readCalls(t, log); !slices.Equal(got, expand(tt.wantStop)) {
+				t.Errorf("stop ran %v, want %v", got, expand(tt.wantStop))
+			}
+
+			if err := m.disable(&DisableCommand{RootDir: rootDir}); err != nil {
+				t.Fatalf("disable: %v", err)
+			}
+			if got := readCalls(t, log); !slices.Equal(got, expand(tt.wantDisable)) {
+				t.Errorf("disable ran %v, want %v", got, expand(tt.wantDisable))
+			}
+			if pathExists(servicePath) {
+				t.Errorf("disable left %s", servicePath)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Launch settings can be overridden per runner in its ghrunner.env using the
+// same variables as the start flags. They aren't passed on to jobs.
+const (
+	launchEnvMode       = "GHRUNNER_LAUNCH"
+	launchEnvShell      = "GHRUNNER_SHELL"
+	launchEnvShellFlags = "GHRUNNER_SHELL_FLAGS"
+)
+
+// directEnvKeys are the variables inherited from ghrunner in direct launch mode
+var directEnvKeys = []string{"PATH", "HOME", "USER", "LOGNAME", "LANG", "LC_ALL", "TZ", "TMPDIR"}
+
+// launchOptions describe how run.sh is started on the host
+type launchOptions struct {
+	Mode       string
+	Shell      string
+	ShellFlags []string
+}
+
+// launchOptions returns the launch settings for a runner, taking overrides
+// out of its environment
+func (s *StartCommand) launchOptions(env map[string]string) (launchOptions, error) {
+	opts := launchOptions{Mode: s.Launch, Shell: s.Shell}
+	flags := s.ShellFlags
+
+	if mode, ok := env[launchEnvMode]; ok {
+		opts.Mode = mode
+		delete(env, launchEnvMode)
+	}
+	if shell, ok := env[launchEnvShell]; ok {
+		opts.Shell = shell
+		delete(env, launchEnvShell)
+	}
+	if shellFlags, ok := env[launchEnvShellFlags]; ok {
+		flags = shellFlags
+		delete(env, launchEnvShellFlags)
+	}
+
+	// Load the user's environment through a login shell by default
+	// macOS: /bin/zsh -lic
+	// Linux: /bin/bash -lc
+	if opts.Shell == "" {
+		if runtime.GOOS == "darwin" {
+			opts.Shell = "/bin/zsh"
+		} else {
+			opts.Shell = "/bin/bash"
+		}
+	}
+	if flags == "" {
+		if runtime.GOOS == "darwin" {
+			flags = "-lic"
+		} else {
+			flags = "-lc"
+		}
+	}
+	opts.ShellFlags = strings.Fields(flags)
+
+	if opts.Mode != "shell" && opts.Mode != "direct" {
+		return opts, fmt.Errorf("invalid launch mode %q, expected shell or direct", opts.Mode)
+	}
+	return opts, nil
+}
+
+// hostCommand returns the command running one iteration of the runner on the
+// host and the environment it starts from
+func (opts launchOptions) hostCommand(dir string) (*exec.Cmd, []string) {
+	if opts.Mode == "direct" {
+		// Exec run.sh without a shell, passing on only a few basic variables
+		var env []string
+		for _, key := range directEnvKeys {
+			if value, ok := os.LookupEnv(key); ok {
+				env = append(env, key+"="+value)
+			}
+		}
+		return exec.Command("./run.sh", "--once"), env
+	}
+
+	runScript := fmt.Sprintf("cd %s && exec ./run.sh --once", shellQuote(dir))
+	args := append(append([]string{}, opts.ShellFlags...), runScript)
+	return exec.Command(opts.Shell, args...), os.Environ()
+}
+
+// shellQuote quotes s for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
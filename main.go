@@ -9,11 +9,14 @@ import (
 )
 
 type Cli struct {
-	Setup   SetupCommand   `cmd:"setup" help:"Setup the GitHub runners"`
-	Enable  EnableCommand  `cmd:"enable" help:"Enable the GitHub runners (create LaunchAgent/systemd services)"`
-	Disable DisableCommand `cmd:"disable" help:"Disable the GitHub runners (remove LaunchAgent/systemd services)"`
-	Start   StartCommand   `cmd:"start" help:"Start the GitHub runners"`
-	Stop    StopCommand    `cmd:"stop" help:"Stop the GitHub runners"`
+	Setup      SetupCommand      `cmd:"setup" help:"Setup the GitHub runners"`
+	Enable     EnableCommand     `cmd:"enable" help:"Enable the GitHub runners (create LaunchAgent/systemd services)"`
+	Render     RenderCommand     `cmd:"render" help:"Print the LaunchAgent/systemd services enable would create"`
+	Disable    DisableCommand    `cmd:"disable" help:"Disable the GitHub runners (remove LaunchAgent/systemd services)"`
+	Start      StartCommand      `cmd:"start" help:"Start the GitHub runners"`
+	Stop       StopCommand       `cmd:"stop" help:"Stop the GitHub runners"`
+	Ctl        CtlCommand        `cmd:"ctl" help:"Pause, resume, drain or restart runners of a running start command"`
+	Quarantine QuarantineCommand `cmd:"quarantine" help:"List and purge workspaces kept from failed runs"`
 }
 
 func main() {
@@ -35,6 +38,10 @@ func searchRunnerDirs(baseDir string) ([]string, error) {
 			return err
 		}
 		if d.IsDir() {
+			// Quarantined workspaces may contain anything, including run.sh files
+			if d.Name() == quarantineDirName {
+				return filepath.SkipDir
+			}
 			runShPath := filepath.Join(path, "run.sh")
 			if _, err := os.Stat(runShPath); err == nil {
 				result = append(result, path)
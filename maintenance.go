@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maintenanceHoldKey identifies the maintenance window hold in the supervisor
+const maintenanceHoldKey = "maintenance"
+
+// maintenanceDrainCheckInterval is how often the maintenance command waits
+// for the last runners to stop
+const maintenanceDrainCheckInterval = 5 * time.Second
+
+// maintenanceTimeFormat is how window times are shown in logs and ctl status
+const maintenanceTimeFormat = "Mon 2006-01-02 15:04 MST"
+
+// maintenanceWindow is a recurring window from --maintenance-window
+type maintenanceWindow struct {
+	schedule *cronSchedule
+	duration time.Duration
+}
+
+// parseMaintenanceWindow parses a cron expression followed by the window's
+// duration, e.g. "0 3 * * sun 2h"
+func parseMaintenanceWindow(spec string) (maintenanceWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 6 {
+		return maintenanceWindow{}, fmt.Errorf("invalid maintenance window %q: expected a cron expression and a duration", spec)
+	}
+	duration, err := time.ParseDuration(fields[5])
+	if err != nil || duration <= 0 {
+		return maintenanceWindow{}, fmt.Errorf("invalid maintenance window duration %q", fields[5])
+	}
+	schedule, err := parseCron(strings.Join(fields[:5], " "))
+	if err != nil {
+		return maintenanceWindow{}, err
+	}
+	return maintenanceWindow{schedule: schedule, duration: duration}, nil
+}
+
+func (s *StartCommand) maintenanceWindows() ([]maintenanceWindow, error) {
+	var windows []maintenanceWindow
+	for _, spec := range s.MaintenanceWindows {
+		window, err := parseMaintenanceWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// nextMaintenance returns the start and end of the window that is open at
+// now or opens first after it, or zero times if there is none
+func nextMaintenance(windows []maintenanceWindow, now time.Time) (start, end time.Time) {
+	for _, w := range windows {
+		// A window that started less than its duration ago is still open
+		next := w.schedule.next(now.Add(-w.duration))
+		if next.IsZero() {
+			continue
+		}
+		if start.IsZero() || next.Before(start) {
+			start, end = next, next.Add(w.duration)
+		}
+	}
+	return start, end
+}
+
+// runMaintenance holds the runners from MaintenanceDrainAhead before each
+// window until its end, running MaintenanceCommand once they have all stopped
+func (s *StartCommand) runMaintenance(ctx context.Context, sv *supervisor, windows []maintenanceWindow) {
+	for {
+		start, end := nextMaintenance(windows, time.Now())
+		if start.IsZero() {
+			sv.setMaintenance("no upcoming window")
+			return
+		}
+		window := fmt.Sprintf("%s - %s", start.Format(maintenanceTimeFormat), end.Format(maintenanceTimeFormat))
+
+		drainAt := start.Add(-s.MaintenanceDrainAhead)
+		if time.Now().Before(drainAt) {
+			fmt.Printf("Next maintenance window: %s\n", window)
+			sv.setMaintenance("next window " + window)
+			if !sleepUntil(ctx, drainAt) {
+				return
+			}
+		}
+
+		sv.hold(maintenanceHoldKey, "maintenance window "+window)
+		if time.Now().Before(start) {
+			sv.setMaintenance("draining for window " + window)
+			if !sleepUntil(ctx, start) {
+				return
+			}
+		}
+
+		fmt.Printf("Maintenance window started: %s\n", window)
+		sv.setMaintenance("in window " + window)
+		s.maintenanceCommand(ctx, sv, end)
+
+		if !sleepUntil(ctx, end) {
+			return
+		}
+		sv.release(maintenanceHoldKey)
+		fmt.Printf("Maintenance window ended: %s\n", window)
+	}
+}
+
+// maintenanceCommand waits for every runner to stop and runs
+// MaintenanceCommand, giving up or killing it when the window ends.
+// HookTimeout doesn't apply, upgrades may take longer than hooks.
+func (s *StartCommand) maintenanceCommand(ctx context.Context, sv *supervisor, end time.Time) {
+	if s.MaintenanceCommand == "" {
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, end)
+	defer cancel()
+
+	for running := sv.runningCount(); running > 0; running = sv.runningCount() {
+		fmt.Printf("Maintenance waiting for %d runners to finish their jobs...\n", running)
+		sleepContext(ctx, maintenanceDrainCheckInterval)
+		if ctx.Err() != nil {
+			fmt.Println("Maintenance window ended before all runners stopped, skipping maintenance command")
+			return
+		}
+	}
+
+	fmt.Println("Running maintenance command...")
+	if err := s.runCommand(ctx, "maintenance", s.MaintenanceCommand, s.RootDir, "GHRUNNER_MAINTENANCE_END="+end.Format(time.RFC3339)); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			fmt.Println("Maintenance command killed at the end of the maintenance window")
+		} else {
+			fmt.Printf("Maintenance command failed: %v\n", err)
+		}
+		return
+	}
+	fmt.Println("Maintenance command finished")
+}
+
+// sleepUntil waits until t, returning false if ctx is done first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	sleepContext(ctx, time.Until(t))
+	return ctx.Err() == nil
+}
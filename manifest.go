@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// manifestFileName is the manifest in the root directory recording what
+// ghrunner set up: runners, services and users
+const manifestFileName = "ghrunner.json"
+
+// manifestVersion is the format version of the manifest
+const manifestVersion = 1
+
+// Manifest records what ghrunner manages, so commands act on what it set
+// up instead of guessing from the directory tree, and disable never undoes
+// anything it didn't do, such as deleting a user that existed before enable
+type Manifest struct {
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Runners  []ManifestRunner  `json:"runners,omitempty"`
+	Services []ManifestService `json:"services,omitempty"`
+
+	// CreatedUsers are the Linux users created by enable
+	CreatedUsers []string `json:"created_users,omitempty"`
+
+	// Usernames maps orgs, or <org>/<name> runners with --isolation=runner,
+	// to the Linux users running them
+	Usernames map[string]string `json:"usernames,omitempty"`
+
+	// EnableOptions are the flags of the last enable shaping the services,
+	// which enable --check compares against
+	EnableOptions []string `json:"enable_options,omitempty"`
+}
+
+// ManifestRunner is a runner directory and its registration
+type ManifestRunner struct {
+	Name      string    `json:"name"` // <org>/<name> relative to the root directory
+	Org       string    `json:"org"`
+	ID        int64     `json:"id,omitempty"`         // runner ID on GitHub
+	AgentName string    `json:"agent_name,omitempty"` // name the runner registered with
+	URL       string    `json:"url,omitempty"`        // URL the runner registered to
+	Scope     string    `json:"scope,omitempty"`      // repo, org or enterprise
+	Version   string    `json:"version,omitempty"`    // actions/runner version
+	Labels    []string  `json:"labels,omitempty"`     // --additional-labels given to setup
+	AddedAt   time.Time `json:"added_at"`
+}
+
+// ManifestService is a service installed by enable
+type ManifestService struct {
+	Name        string    `json:"name"`           // systemd unit without .service, or launchd label
+	Manager     string    `json:"manager"`        // systemd, systemd-user or launchd
+	Path        string    `json:"path,omitempty"` // service file, empty for template instances
+	User        string    `json:"user,omitempty"` // user the service runs as
+	Runners     []string  `json:"runners,omitempty"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// runnerConfig is the part of a runner's .runner file written by config.sh
+type runnerConfig struct {
+	AgentID   int64  `json:"agentId"`
+	AgentName string `json:"agentName"`
+	GitHubURL string `json:"gitHubUrl"`
+}
+
+// loadManifest reads the manifest of a root directory, returning nil if there is none
+func loadManifest(rootDir string) (*Manifest, error) {
+	path := filepath.Join(rootDir, manifestFileName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// updateManifest changes the manifest of a root directory under a lock so
+// concurrent commands don't lose each other's changes, replacing the file
+// atomically
+func updateManifest(rootDir string, update func(m *Manifest) error) error {
+	dir, err := os.Open(rootDir)
+	if err != nil {
+		return fmt.Errorf("failed to open root directory: %w", err)
+	}
+	defer dir.Close()
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock manifest: %w", err)
+	}
+
+	m, err := loadManifest(rootDir)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		m = &Manifest{}
+	}
+	if err := update(m); err != nil {
+		return err
+	}
+	m.Version = manifestVersion
+	m.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(rootDir, manifestFileName+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	// Services running as other users read the manifest too
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(rootDir, manifestFileName)); err != nil {
+		return fmt.Errorf("failed to replace manifest: %w", err)
+	}
+	return nil
+}
+
+// scanRunners walks the root directory for runners and records them in the
+// manifest, forgetting runners whose directories are gone
+func scanRunners(rootDir string) ([]string, error) {
+	runnerDirs, err := searchRunnerDirs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search runner dirs: %w", err)
+	}
+	err = updateManifest(rootDir, func(m *Manifest) error {
+		m.syncRunners(rootDir, runnerDirs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return runnerDirs, nil
+}
+
+// manifestRunners returns the runner directories under dir recorded in the
+// manifest. dir is a root directory, or an org directory when start runs
+// for a single org, which uses the manifest of the root directory above it.
+// Without a manifest it falls back to walking dir.
+func manifestRunners(dir string) ([]string, error) {
+	rootDir, m, err := manifestRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil || len(m.Runners) == 0 {
+		runnerDirs, err := searchRunnerDirs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search runner dirs: %w", err)
+		}
+		return runnerDirs, nil
+	}
+
+	var runnerDirs []string
+	for _, r := range m.Runners {
+		runnerDir := filepath.Join(rootDir, filepath.FromSlash(r.Name))
+		if rel, err := filepath.Rel(dir, runnerDir); err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		// Skip runners removed since the manifest was written
+		if !pathExists(filepath.Join(runnerDir, "run.sh")) {
+			continue
+		}
+		runnerDirs = append(runnerDirs, runnerDir)
+	}
+	return runnerDirs, nil
+}
+
+// manifestRoot returns the root directory holding the manifest that covers
+// dir, either dir itself or the directory above an org directory, and the
+// manifest, which is nil when there is none
+func manifestRoot(dir string) (string, *Manifest, error) {
+	m, err := loadManifest(dir)
+	if err != nil || m != nil {
+		return dir, m, err
+	}
+	rootDir := filepath.Dir(dir)
+	m, err = loadManifest(rootDir)
+	if err != nil || m == nil {
+		return dir, nil, err
+	}
+	return rootDir, m, nil
+}
+
+// syncRunners replaces the recorded runners with runnerDirs, keeping what
+// is known about runners recorded before
+func (m *Manifest) syncRunners(rootDir string, runnerDirs []string) {
+	var runners []ManifestRunner
+	for _, runnerDir := range runnerDirs {
+		runners = append(runners, m.runner(rootDir, runnerDir))
+	}
+	sort.Slice(runners, func(i, j int) bool { return runners[i].Name < runners[j].Name })
+	m.Runners = runners
+}
+
+// addRunner records a runner set up by setup with its runner version and labels
+func (m *Manifest) addRunner(rootDir, runnerDir, version string, labels []string) {
+	r := m.runner(rootDir, runnerDir)
+	if version != "" {
+		r.Version = version
+	}
+	r.Labels = labels
+	runners := slices.DeleteFunc(m.Runners, func(existing ManifestRunner) bool { return existing.Name == r.Name })
+	m.Runners = append(runners, r)
+	sort.Slice(m.Runners, func(i, j int) bool { return m.Runners[i].Name < m.Runners[j].Name })
+}
+
+// runner returns the manifest entry of a runner directory, refreshed from
+// its .runner file
+func (m *Manifest) runner(rootDir, runnerDir string) ManifestRunner {
+	name := runnerRelName(rootDir, runnerDir)
+	r := ManifestRunner{Name: name, AddedAt: time.Now()}
+	for _, existing := range m.Runners {
+		if existing.Name == name {
+			r = existing
+		}
+	}
+	r.Org, _, _ = strings.Cut(name, "/")
+
+	// config.sh writes .runner with a byte order mark
+	if data, err := os.ReadFile(filepath.Join(runnerDir, ".runner")); err == nil {
+		var config runnerConfig
+		if json.Unmarshal(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), &config) == nil {
+			r.ID = config.AgentID
+			r.AgentName = config.AgentName
+			r.URL = config.GitHubURL
+			r.Scope = registrationScope(config.GitHubURL)
+		}
+	}
+	if version := installedRunnerVersion(runnerDir); version != "" {
+		r.Version = version
+	}
+	return r
+}
+
+// runnerLabels returns the labels setup registered the runners with, sorted
+// and without duplicates, for service templates
+func runnerLabels(rootDir string, runnerDirs []string) []string {
+	m, err := loadManifest(rootDir)
+	if err != nil || m == nil {
+		return nil
+	}
+	var labels []string
+	for _, runnerDir := range runnerDirs {
+		name := runnerRelName(rootDir, runnerDir)
+		for _, r := range m.Runners {
+			if r.Name == name {
+				labels = append(labels, r.Labels...)
+			}
+		}
+	}
+	slices.Sort(labels)
+	return slices.Compact(labels)
+}
+
+// registrationScope returns whether a runner URL registers to a repo, an
+// org or an enterprise
+func registrationScope(runnerURL string) string {
+	u, err := url.Parse(runnerURL)
+	if err != nil || u.Path == "" {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "enterprises":
+		return "enterprise"
+	case len(parts) == 2:
+		return "repo"
+	case len(parts) == 1:
+		return "org"
+	default:
+		return ""
+	}
+}
+
+// installedRunnerVersion returns the runner version a self-updated runner
+// switched to, whose bin directory links to bin.<version>
+func installedRunnerVersion(runnerDir string) string {
+	target, err := os.Readlink(filepath.Join(runnerDir, "bin"))
+	if err != nil {
+		return ""
+	}
+	version, ok := strings.CutPrefix(filepath.Base(target), "bin.")
+	if !ok {
+		return ""
+	}
+	return version
+}
+
+// setServices replaces the recorded services of a service manager
+func (m *Manifest) setServices(manager string, services []ManifestService) {
+	m.Services = slices.DeleteFunc(m.Services, func(s ManifestService) bool { return s.Manager == manager })
+	m.Services = append(m.Services, services...)
+}
+
+// services returns the recorded services of a service manager
+func (m *Manifest) services(manager string) []ManifestService {
+	var services []ManifestService
+	for _, s := range m.Services {
+		if s.Manager == manager {
+			services = append(services, s)
+		}
+	}
+	return services
+}
+
+// launchAgentPath returns the LaunchAgent plist recorded in the manifest,
+// or where enable installs it
+func launchAgentPath(rootDir string) (string, error) {
+	m, err := loadManifest(rootDir)
+	if err != nil {
+		return "", err
+	}
+	if m != nil {
+		if services := m.services("launchd"); len(services) > 0 {
+			return services[0].Path, nil
+		}
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, "Library", "LaunchAgents", launchAgentLabel+".plist"), nil
+}
+
+// recordCreatedUser adds a user created by enable to the manifest
+func recordCreatedUser(rootDir, username string) error {
+	return updateManifest(rootDir, func(m *Manifest) error {
+		if !slices.Contains(m.CreatedUsers, username) {
+			m.CreatedUsers = append(m.CreatedUsers, username)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// poolCheckInterval is how often the pool scaler looks at the runner states
+const poolCheckInterval = 5 * time.Second
+
+// poolEnabled reports whether runners are brought up on demand instead of all at once
+func (s *StartCommand) poolEnabled() bool {
+	return s.PoolMax > 0
+}
+
+func (s *StartCommand) validatePool() error {
+	if !s.poolEnabled() {
+		return nil
+	}
+	if s.PoolMin < 0 || (s.PoolMin == 0 && s.WebhookListen == "") {
+		return fmt.Errorf("--pool-min must be at least 1, or 0 with --webhook-listen")
+	}
+	if s.PoolMin > s.PoolMax {
+		return fmt.Errorf("--pool-min (%d) must not be greater than --pool-max (%d)", s.PoolMin, s.PoolMax)
+	}
+	return nil
+}
+
+// runPool scales the pool until ctx is done
+func (sv *supervisor) runPool(ctx context.Context) {
+	ticker := time.NewTicker(poolCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		sv.scale()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-sv.scaleNow:
+		}
+	}
+}
+
+// scale brings standby runners up while fewer than --pool-min active runners,
+// or fewer than the jobs queued according to the webhook, are idle, up to
+// --pool-max active runners, and retires runners that have been idle for
+// --pool-idle-timeout while more than that are idle.
+// A runner is busy from the "Running job" line of its output until the job
+// completes. Paused runners are left to the operator and not counted.
+func (sv *supervisor) scale() {
+	start := sv.start
+
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	var dirs []string
+	for dir := range sv.runners {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var idle, standby []*runner
+	active := 0
+	for _, dir := range dirs {
+		r := sv.runners[dir]
+		r.mu.Lock()
+		paused, onStandby := r.paused, r.standby
+		r.mu.Unlock()
+
+		switch {
+		case paused:
+		case onStandby:
+			standby = append(standby, r)
+		default:
+			active++
+			if !r.busy.Load() {
+				// Starting runners count as idle so they aren't started twice
+				idle = append(idle, r)
+			}
+		}
+	}
+
+	target := max(start.PoolMin, sv.queue.pending())
+
+	// Scale up in directory order
+	need := min(target-len(idle), start.PoolMax-active, len(standby))
+	for _, r := range standby[:max(need, 0)] {
+		active++
+		fmt.Printf("Pool: starting runner %s (%d idle, %d of max %d active)\n", r.dir, len(idle), active, start.PoolMax)
+		r.activate()
+	}
+
+	// Retire the last idle runners first, keeping the pool stable
+	extra := len(idle) - target
+	for i := len(idle) - 1; i >= 0 && extra > 0; i-- {
+		r := idle[i]
+		idleFor := r.idleFor()
+		if idleFor < start.PoolIdleTimeout {
+			continue
+		}
+		fmt.Printf("Pool: retiring runner %s after %s idle\n", r.dir, idleFor.Round(time.Second))
+		r.request(actionRetire)
+		extra--
+	}
+}
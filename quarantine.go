@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// quarantineDirName is the default quarantine directory under the root directory.
+// searchRunnerDirs never looks inside it.
+const quarantineDirName = "_quarantine"
+
+// quarantineInfoFile holds the QuarantineInfo of a quarantined workspace
+const quarantineInfoFile = "quarantine.json"
+
+// quarantineMu serializes moves and evictions between runner loops
+var quarantineMu sync.Mutex
+
+// QuarantineInfo describes why and when a workspace was quarantined
+type QuarantineInfo struct {
+	Runner   string    `json:"runner"`
+	ExitCode int       `json:"exit_code"`
+	Reason   string    `json:"reason,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// quarantinedWorkspace is a quarantined workspace found on disk
+type quarantinedWorkspace struct {
+	ID   string
+	Path string
+	Info QuarantineInfo
+	Size int64
+}
+
+// quarantinePolicy limits how many failed workspaces are kept and how much space they use
+type quarantinePolicy struct {
+	Dir     string
+	Keep    int
+	MaxSize ByteSize
+
+	// Private makes Dir accessible only to its owner, Owner if set
+	Private bool
+	Owner   *syscall.Credential
+}
+
+// quarantineWorkDir moves a failed run's work directory into the quarantine
+// directory and evicts the oldest workspaces beyond the policy limits.
+// It returns the quarantined path, or "" if there was nothing to keep.
+func (p quarantinePolicy) quarantineWorkDir(runnerDir, workDir string, exitCode int, reason string) (string, error) {
+	if p.Keep <= 0 {
+		return "", nil
+	}
+	entries, err := os.ReadDir(workDir)
+	if err != nil || len(entries) == 0 {
+		return "", nil
+	}
+
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+
+	now := time.Now()
+	if err := p.prepareDir(); err != nil {
+		return "", err
+	}
+	// IDs have second resolution, runs failing within the same second get a counter
+	base := fmt.Sprintf("%s-%s_%s", now.Format("20060102-150405"), filepath.Base(filepath.Dir(runnerDir)), filepath.Base(runnerDir))
+	id := base
+	dest := filepath.Join(p.Dir, id)
+	for n := 2; ; n++ {
+		err := os.Mkdir(dest, 0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		id = fmt.Sprintf("%s-%d", base, n)
+		dest = filepath.Join(p.Dir, id)
+	}
+
+	// Rename stays on the same filesystem as long as the quarantine dir lives under the root dir
+	if err := os.Rename(workDir, filepath.Join(dest, "_work")); err != nil {
+		os.RemoveAll(dest)
+		return "", fmt.Errorf("failed to move %s: %w", workDir, err)
+	}
+
+	info := QuarantineInfo{Runner: runnerDir, ExitCode: exitCode, Reason: reason, Time: now}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dest, quarantineInfoFile), data, 0644); err != nil {
+		return "", err
+	}
+	if p.Owner != nil {
+		for _, path := range []string{dest, filepath.Join(dest, quarantineInfoFile)} {
+			if err := os.Chown(path, int(p.Owner.Uid), int(p.Owner.Gid)); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err := p.evict(id); err != nil {
+		return dest, fmt.Errorf("failed to evict old quarantined workspaces: %w", err)
+	}
+	return dest, nil
+}
+
+// prepareDir creates the quarantine directory, restricting it to its owner
+// when Private
+func (p quarantinePolicy) prepareDir() error {
+	if !p.Private {
+		return os.MkdirAll(p.Dir, 0755)
+	}
+	if err := os.MkdirAll(p.Dir, 0700); err != nil {
+		return err
+	}
+	if p.Owner != nil {
+		if err := os.Chown(p.Dir, int(p.Owner.Uid), int(p.Owner.Gid)); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(p.Dir, 0700)
+}
+
+// evict removes the oldest workspaces until the policy limits are met, never removing keepID
+func (p quarantinePolicy) evict(keepID string) error {
+	workspaces, err := listQuarantine(p.Dir)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, w := range workspaces {
+		total += w.Size
+	}
+
+	// listQuarantine returns the oldest first
+	count := len(workspaces)
+	for _, w := range workspaces {
+		overCount := count > p.Keep
+		overSize := p.MaxSize > 0 && total > int64(p.MaxSize)
+		if !overCount && !overSize {
+			break
+		}
+		if w.ID == keepID {
+			continue
+		}
+		if err := os.RemoveAll(w.Path); err != nil {
+			return err
+		}
+		fmt.Printf("Evicted quarantined workspace: %s\n", w.ID)
+		count--
+		total -= w.Size
+	}
+	return nil
+}
+
+// listQuarantine returns the quarantined workspaces in dir, oldest first
+func listQuarantine(dir string) ([]quarantinedWorkspace, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result []quarantinedWorkspace
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		w := quarantinedWorkspace{ID: entry.Name(), Path: path}
+
+		if data, err := os.ReadFile(filepath.Join(path, quarantineInfoFile)); err == nil {
+			json.Unmarshal(data, &w.Info)
+		}
+		if w.Info.Time.IsZero() {
+			if fi, err := entry.Info(); err == nil {
+				w.Info.Time = fi.ModTime()
+			}
+		}
+		w.Size = dirSize(path)
+		result = append(result, w)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Info.Time.Before(result[j].Info.Time) })
+	return result, nil
+}
+
+// dirSize returns the total size of the regular files under path
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if fi, err := d.Info(); err == nil {
+				size += fi.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+type QuarantineCommand struct {
+	RootDir       string `name:"root-dir" type:"path" help:"Root directory" env:"ROOT_RUNNERS_DIR" default:"~/.github-runners"`
+	QuarantineDir string `name:"quarantine-dir" type:"path" help:"Quarantine directory (defaults to _quarantine in the root directory)" env:"GHRUNNER_QUARANTINE_DIR"`
+
+	List  QuarantineListCommand  `cmd:"list" help:"List quarantined workspaces"`
+	Purge QuarantinePurgeCommand `cmd:"purge" help:"Remove quarantined workspaces"`
+}
+
+// list returns the quarantined workspaces, oldest first: those in
+// --quarantine-dir, or in the root directory and the runner directories,
+// where start --run-as-owner keeps them
+func (q *QuarantineCommand) list() ([]quarantinedWorkspace, error) {
+	if q.QuarantineDir != "" {
+		return listQuarantine(q.QuarantineDir)
+	}
+	dirs := []string{filepath.Join(q.RootDir, quarantineDirName)}
+	runnerDirs, err := manifestRunners(q.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, runnerDir := range runnerDirs {
+		dirs = append(dirs, filepath.Join(runnerDir, quarantineDirName))
+	}
+
+	var workspaces []quarantinedWorkspace
+	for _, dir := range dirs {
+		found, err := listQuarantine(dir)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, found...)
+	}
+	sort.SliceStable(workspaces, func(i, j int) bool { return workspaces[i].Info.Time.Before(workspaces[j].Info.Time) })
+	return workspaces, nil
+}
+
+type QuarantineListCommand struct{}
+
+func (l *QuarantineListCommand) Run(q *QuarantineCommand) error {
+	workspaces, err := q.list()
+	if err != nil {
+		return fmt.Errorf("failed to list quarantine: %w", err)
+	}
+	if len(workspaces) == 0 {
+		fmt.Println("No quarantined workspaces")
+		return nil
+	}
+
+	var total int64
+	for _, w := range workspaces {
+		exit := fmt.Sprintf("exit=%d", w.Info.ExitCode)
+		if w.Info.Reason != "" {
+			exit += " (" + w.Info.Reason + ")"
+		}
+		fmt.Printf("%-40s %-20s %10s  %s\n", w.ID, exit, ByteSize(w.Size).Human(), w.Info.Runner)
+		total += w.Size
+	}
+	fmt.Printf("\n%d workspaces, %s total\n", len(workspaces), ByteSize(total).Human())
+	return nil
+}
+
+type QuarantinePurgeCommand struct {
+	IDs       []string      `arg:"" optional:"" help:"Workspaces to remove (all if none given)"`
+	OlderThan time.Duration `name:"older-than" help:"Only remove workspaces quarantined longer ago than this"`
+}
+
+func (p *QuarantinePurgeCommand) Run(q *QuarantineCommand) error {
+	workspaces, err := q.list()
+	if err != nil {
+		return fmt.Errorf("failed to list quarantine: %w", err)
+	}
+
+	wanted := make(map[string]bool)
+	for _, id := range p.IDs {
+		wanted[strings.TrimSuffix(id, "/")] = true
+	}
+
+	removed := 0
+	for _, w := range workspaces {
+		if len(p.IDs) > 0 {
+			if !wanted[w.ID] {
+				continue
+			}
+			delete(wanted, w.ID)
+		}
+		if p.OlderThan > 0 && time.Since(w.Info.Time) < p.OlderThan {
+			continue
+		}
+		if err := os.RemoveAll(w.Path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", w.Path, err)
+		}
+		fmt.Printf("Removed: %s\n", w.ID)
+		removed++
+	}
+
+	for id := range wanted {
+		fmt.Printf("Warning: quarantined workspace not found: %s\n", id)
+	}
+	fmt.Printf("\nRemoved %d workspaces.\n", removed)
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// serviceTemplateFuncs are available in built-in and custom service templates
+var serviceTemplateFuncs = template.FuncMap{
+	// xml escapes a value for plist strings
+	"xml": func(s string) (string, error) {
+		var buf bytes.Buffer
+		err := xml.EscapeText(&buf, []byte(s))
+		return buf.String(), err
+	},
+	// quote quotes a value for systemd Environment= and path lists
+	"quote": systemdQuote,
+	// shquote quotes a value for shell scripts
+	"shquote": shellQuote,
+	// supervisordEnv formats HOME and the variables for supervisord's environment=
+	"supervisordEnv": supervisordEnvironment,
+	// supervisordQuote quotes a value for supervisord's command=
+	"supervisordQuote": supervisordQuote,
+	"join":             strings.Join,
+}
+
+// serviceTemplateKinds are the service files --service-template can replace
+var serviceTemplateKinds = []string{"launchd", "systemd", "systemd-runner", "openrc", "runit", "supervisord"}
+
+// renderTemplate renders the --service-template file given for kind, or
+// the built-in template
+func (e *EnableCommand) renderTemplate(kind, builtin string, data any) (string, error) {
+	for k := range e.ServiceTemplates {
+		if !slices.Contains(serviceTemplateKinds, k) {
+			return "", fmt.Errorf("unknown --service-template kind %q, expected one of %s", k, strings.Join(serviceTemplateKinds, ", "))
+		}
+	}
+
+	name, text := kind, builtin
+	if path := e.ServiceTemplates[kind]; path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read service template: %w", err)
+		}
+		text = string(content)
+		name = filepath.Base(path)
+	}
+
+	tmpl, err := template.New(name).Funcs(serviceTemplateFuncs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// renderLaunchAgent renders and validates the LaunchAgent plist
+func (e *EnableCommand) renderLaunchAgent(config LaunchAgentConfig) (string, error) {
+	content, err := e.renderTemplate("launchd", launchAgentTemplate, config)
+	if err != nil {
+		return "", err
+	}
+	if err := validatePlist(content); err != nil {
+		return content, fmt.Errorf("invalid plist for %s: %w", config.Label, err)
+	}
+	return content, nil
+}
+
+// renderSystemdService renders and validates an org's systemd unit, or the
+// template unit with --layout=runner
+func (e *EnableCommand) renderSystemdService(scope systemdScope, config SystemdServiceConfig) (string, error) {
+	kind, builtin := "systemd", systemdServiceTemplate
+	if e.Layout == "runner" {
+		kind, builtin = "systemd-runner", systemdRunnerTemplate
+	}
+	content, err := e.renderTemplate(kind, builtin, config)
+	if err != nil {
+		return "", err
+	}
+	if err := scope.verify(config.ServiceName+".service", content); err != nil {
+		return content, fmt.Errorf("invalid unit %s: %w", config.ServiceName, err)
+	}
+	return content, nil
+}
+
+// validatePlist checks that a plist is well-formed XML with a plist root element
+func validatePlist(content string) error {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	root := ""
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if start, ok := token.(xml.StartElement); ok && root == "" {
+			root = start.Name.Local
+		}
+	}
+	if root != "plist" {
+		return fmt.Errorf("root element is %q, expected plist", root)
+	}
+	return nil
+}
+
+// verify runs systemd-analyze verify on a unit when it is installed,
+// printing its warnings to stderr
+func (sc systemdScope) verify(name, content string) error {
+	analyze, err := exec.LookPath("systemd-analyze")
+	if err != nil {
+		return nil
+	}
+
+	// systemd-analyze derives the unit name from the file name
+	dir, err := os.MkdirTemp("", "ghrunner-verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return err
+	}
+
+	args := []string{"verify"}
+	if sc.user {
+		args = []string{"--user", "verify"}
+	}
+	out, err := exec.Command(analyze, append(args, path)...).CombinedOutput()
+	message := strings.ReplaceAll(strings.TrimSpace(string(out)), path, name)
+	// Errors about the unit name it, failures of systemd-analyze itself, such
+	// as no user manager to connect to outside a session, don't
+	if err != nil && strings.Contains(message, strings.TrimSuffix(name, ".service")) {
+		return fmt.Errorf("systemd-analyze verify failed: %s", message)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not verify %s: %s\n", name, message)
+		return nil
+	}
+	if message != "" {
+		// Unknown keys and the like are only warnings
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", message)
+	}
+	return nil
+}
+
+type RenderCommand struct {
+	EnableCommand `embed:""`
+
+	Org string `name:"org" help:"Only render the service of this org (Linux)"`
+}
+
+// Run prints the service files enable would install without changing
+// anything, with their paths on stderr
+func (r *RenderCommand) Run() error {
+	// RenderCommand embeds EnableCommand for its service options only
+	switch {
+	case r.Check, r.Fix:
+		return fmt.Errorf("--check and --fix only apply to enable")
+	case r.AdoptUsers:
+		return fmt.Errorf("--adopt-users only applies to enable")
+	}
+
+	manager, err := newServiceManager(r.Init)
+	if err != nil {
+		return err
+	}
+	orgs, orgNames, err := r.orgRunners()
+	if err != nil {
+		return err
+	}
+	exePath, err := executablePath()
+	if err != nil {
+		return err
+	}
+	return manager.render(r, exePath, orgs, orgNames)
+}
+
+// selectedOrgs returns the orgs to render, only --org if given
+func (r *RenderCommand) selectedOrgs(orgs map[string][]string, orgNames []string) ([]string, error) {
+	if r.Org == "" {
+		return orgNames, nil
+	}
+	if _, ok := orgs[r.Org]; !ok {
+		return nil, fmt.Errorf("no runners found for org %s", r.Org)
+	}
+	return []string{r.Org}, nil
+}
+
+// printRendered prints a rendered service file, separating files by a blank line
+func printRendered(i int, path, content string) {
+	if i > 0 {
+		fmt.Println()
+	}
+	fmt.Fprintf(os.Stderr, "# %s\n", path)
+	fmt.Print(content)
+}
+
+func (r *RenderCommand) renderMacOS(exePath string, orgs map[string][]string, orgNames []string) error {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	var runnerDirs []string
+	for _, org := range orgNames {
+		runnerDirs = append(runnerDirs, orgs[org]...)
+	}
+	config, err := r.launchAgentConfig(exePath, homeDir, runnerDirs)
+	if err != nil {
+		return err
+	}
+	plistPath, err := launchAgentPath(r.RootDir)
+	if err != nil {
+		return err
+	}
+	content, err := r.renderLaunchAgent(config)
+	printRendered(0, plistPath, content)
+	return err
+}
+
+func (r *RenderCommand) renderSystemd(exePath string, orgs map[string][]string, orgNames []string) error {
+	scope := systemdScope{user: r.User}
+	unitDir, err := scope.unitDir()
+	if err != nil {
+		return err
+	}
+	orgNames, err = r.selectedOrgs(orgs, orgNames)
+	if err != nil {
+		return err
+	}
+	if r.Layout == "runner" {
+		config, err := r.systemdRunnerConfig(scope, exePath, orgs, orgNames)
+		if err != nil {
+			return err
+		}
+		content, err := r.renderSystemdService(scope, config)
+		printRendered(0, filepath.Join(unitDir, systemdRunnerUnit), content)
+		return err
+	}
+	for i, org := range orgNames {
+		config, err := r.systemdServiceConfig(scope, exePath, org, orgs[org])
+		if err != nil {
+			return err
+		}
+		content, err := r.renderSystemdService(scope, config)
+		printRendered(i, filepath.Join(unitDir, config.ServiceName+".service"), content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
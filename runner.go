@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runnerAction is a control request for a single runner
+type runnerAction string
+
+const (
+	actionNone    runnerAction = ""
+	actionPause   runnerAction = "pause"
+	actionResume  runnerAction = "resume"
+	actionDrain   runnerAction = "drain"
+	actionRestart runnerAction = "restart"
+
+	// actionHold stops the runner like a drain without pausing it, so it
+	// comes back once the supervisor holds are cleared
+	actionHold runnerAction = "hold"
+
+	// actionRetire stops an idle runner the pool no longer needs
+	actionRetire runnerAction = "retire"
+)
+
+// runner tracks the state of a single supervised runner directory
+type runner struct {
+	dir  string
+	busy atomic.Bool
+
+	// jobStartedAt is the UnixNano time the current job started, 0 when idle
+	jobStartedAt atomic.Int64
+
+	// idleSince is the UnixNano time the runner last became idle
+	idleSince atomic.Int64
+
+	// Counters reported by ctl status
+	runs     atomic.Int64
+	failures atomic.Int64
+	timeouts atomic.Int64
+	oomKills atomic.Int64
+
+	// cancel stops the runner loop, done is closed once it has returned
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// holds is shared by every runner of the supervisor
+	holds *holds
+
+	// Control state, the loop is woken up through wake when it changes
+	mu      sync.Mutex
+	paused  bool
+	standby bool
+	running bool
+	pending runnerAction
+	wake    chan struct{}
+}
+
+func newRunner(dir string, h *holds) *runner {
+	return &runner{
+		dir:   dir,
+		holds: h,
+		done:  make(chan struct{}),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// request records a control action for the runner loop to pick up
+func (r *runner) request(action runnerAction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch action {
+	case actionPause, actionDrain:
+		if r.paused && !r.running {
+			return fmt.Errorf("runner %s is already paused", r.dir)
+		}
+		r.paused = true
+	case actionResume:
+		if !r.paused {
+			return fmt.Errorf("runner %s is not paused", r.dir)
+		}
+		r.paused = false
+		action = actionNone
+	case actionRestart:
+		r.paused = false
+	case actionRetire:
+		r.standby = true
+		if !r.running || r.pending != actionNone {
+			return nil
+		}
+	case actionHold:
+		if !r.running || r.pending != actionNone {
+			// Nothing to stop, or a stronger action is already queued
+			return nil
+		}
+	default:
+		return fmt.Errorf("unknown action: %s", action)
+	}
+	r.pending = action
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// takeAction returns and clears the pending control action
+func (r *runner) takeAction() runnerAction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	action := r.pending
+	r.pending = actionNone
+	return action
+}
+
+// activate takes the runner out of standby
+func (r *runner) activate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.standby = false
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// waitResumed blocks while the runner is paused, on standby or held,
+// returning false if ctx is done first
+func (r *runner) waitResumed(ctx context.Context) bool {
+	announced := false
+	for {
+		r.mu.Lock()
+		paused := r.paused || r.standby
+		r.mu.Unlock()
+		held, changed := r.holds.active()
+
+		if !paused && len(held) == 0 {
+			r.mu.Lock()
+			r.pending = actionNone
+			r.mu.Unlock()
+			return true
+		}
+
+		if !paused && !announced {
+			fmt.Printf("Runner %s held: %s\n", r.dir, strings.Join(held, "; "))
+			announced = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-r.wake:
+		case <-changed:
+		}
+	}
+}
+
+func (r *runner) setRunning(running bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running = running
+}
+
+// status describes the runner for ctl status
+func (r *runner) status() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.paused && r.running:
+		return "stopping"
+	case r.paused:
+		return "paused"
+	case r.standby && r.running:
+		return "retiring"
+	case r.standby:
+		return "standby"
+	case !r.running && r.holds.String() != "":
+		return "held"
+	case !r.running:
+		return "starting"
+	case r.busy.Load():
+		return "busy"
+	default:
+		return "idle"
+	}
+}
+
+// observe updates the runner state from a line of run.sh output
+func (r *runner) observe(line string) {
+	switch {
+	case strings.Contains(line, "Running job:"):
+		r.jobStartedAt.Store(time.Now().UnixNano())
+		r.busy.Store(true)
+	case strings.Contains(line, "completed with result:"):
+		r.busy.Store(false)
+		r.jobStartedAt.Store(0)
+		r.idleSince.Store(time.Now().UnixNano())
+	}
+}
+
+// resetJob clears the job state before the runner process starts
+func (r *runner) resetJob() {
+	r.busy.Store(false)
+	r.jobStartedAt.Store(0)
+	r.idleSince.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long the runner has been listening without a job,
+// 0 while it is busy or not running
+func (r *runner) idleFor() time.Duration {
+	r.mu.Lock()
+	running := r.running
+	r.mu.Unlock()
+	if !running || r.busy.Load() {
+		return 0
+	}
+	return time.Since(time.Unix(0, r.idleSince.Load()))
+}
+
+// jobStarted returns when the current job started, or the zero time when idle
+func (r *runner) jobStarted() time.Time {
+	started := r.jobStartedAt.Load()
+	if started == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, started)
+}
+
+// recordExit updates the counters after the runner process exited
+func (r *runner) recordExit(err error, reason string) {
+	r.runs.Add(1)
+	if err != nil {
+		r.failures.Add(1)
+	}
+	switch reason {
+	case exitReasonTimeout:
+		r.timeouts.Add(1)
+	case exitReasonOOMKill:
+		r.oomKills.Add(1)
+	}
+}
+
+// lineWriter writes output to w line by line, masking secrets, and calls fn
+// for every complete line
+type lineWriter struct {
+	w      io.Writer
+	fn     func(line string)
+	masker *secretMasker
+	buf    []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(l.buf[:i+1])
+		l.buf = l.buf[i+1:]
+		if l.fn != nil {
+			l.fn(strings.TrimRight(line, "\r\n"))
+		}
+		if _, err := io.WriteString(l.w, l.masker.mask(line)); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes out a trailing partial line
+func (l *lineWriter) Flush() {
+	if len(l.buf) == 0 {
+		return
+	}
+	io.WriteString(l.w, l.masker.mask(string(l.buf)))
+	l.buf = nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// runnerRelName returns a runner directory relative to the root directory
+// with forward slashes, e.g. org1/hostname-1
+func runnerRelName(rootDir, dir string) string {
+	rel, err := filepath.Rel(rootDir, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return dir
+	}
+	return filepath.ToSlash(rel)
+}
+
+// runnerInstance returns the systemd instance name of a runner in the
+// per-runner service layout, its <org>/<name> escaped like systemd-escape
+// does, e.g. org1-hostname\x2d1, so %I gives back the unambiguous name
+func runnerInstance(rootDir, dir string) string {
+	return systemdEscape(runnerRelName(rootDir, dir))
+}
+
+// isRunner reports whether name, as given to --runner, refers to a runner directory
+func (s *StartCommand) isRunner(name, dir string) bool {
+	return runnerRelName(s.RootDir, dir) == name || runnerInstance(s.RootDir, dir) == name
+}
+
+// matchesAny reports whether a runner's <org>/<name> matches one of the globs
+func (s *StartCommand) matchesAny(patterns []string, dir string) bool {
+	name := runnerRelName(s.RootDir, dir)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRunnerFilters validates the globs and that every runner given with
+// --runner exists, so a typo doesn't silently run nothing
+func (s *StartCommand) checkRunnerFilters(dirs []string) error {
+	for _, pattern := range append(append([]string{}, s.Only...), s.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid runner glob %q: %w", pattern, err)
+		}
+	}
+
+	for _, name := range s.Runners {
+		found := false
+		for _, dir := range dirs {
+			if s.isRunner(name, dir) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("runner not found: %s", name)
+		}
+	}
+	return nil
+}
+
+// filterRunners returns the runner directories selected with --runner,
+// --only and --exclude
+func (s *StartCommand) filterRunners(dirs []string) []string {
+	var result []string
+	for _, dir := range dirs {
+		if len(s.Runners) > 0 {
+			selected := false
+			for _, name := range s.Runners {
+				if s.isRunner(name, dir) {
+					selected = true
+					break
+				}
+			}
+			if !selected {
+				continue
+			}
+		}
+		if len(s.Only) > 0 && !s.matchesAny(s.Only, dir) {
+			continue
+		}
+		if s.matchesAny(s.Exclude, dir) {
+			continue
+		}
+		result = append(result, dir)
+	}
+	return result
+}
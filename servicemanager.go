@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// serviceManager installs and controls the services running ghrunner start
+type serviceManager interface {
+	enable(e *EnableCommand) error
+	check(e *EnableCommand, report *driftReport) error
+	render(r *RenderCommand, exePath string, orgs map[string][]string, orgNames []string) error
+	disable(d *DisableCommand) error
+	stop(s *StopCommand) error
+}
+
+// newServiceManager returns the service manager selected with --init
+func newServiceManager(init string) (serviceManager, error) {
+	if init == "auto" {
+		init = detectInit()
+	}
+	if runtime.GOOS != "linux" && init != "launchd" {
+		return nil, fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+	}
+
+	switch init {
+	case "launchd":
+		if runtime.GOOS != "darwin" {
+			return nil, fmt.Errorf("launchd is only supported on macOS")
+		}
+		return launchdManager{}, nil
+	case "systemd":
+		return systemdManager{}, nil
+	case "openrc":
+		return initManager{backend: openrcBackend{}}, nil
+	case "runit":
+		return initManager{backend: runitBackend{}}, nil
+	case "supervisord":
+		return initManager{backend: supervisordBackend{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown service manager: %s", init)
+	}
+}
+
+// detectInit returns the service manager of the system: launchd on macOS,
+// systemd when it is running, otherwise the first init tool found in PATH
+func detectInit() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "launchd"
+	case "linux":
+	default:
+		return ""
+	}
+
+	if fi, err := os.Stat("/run/systemd/system"); err == nil && fi.IsDir() {
+		return "systemd"
+	}
+	for _, tool := range []struct{ init, binary string }{
+		{"openrc", "openrc-run"},
+		{"runit", "runsvdir"},
+		{"supervisord", "supervisorctl"},
+	} {
+		if _, err := exec.LookPath(tool.binary); err == nil {
+			return tool.init
+		}
+	}
+	return "systemd"
+}
+
+// launchdManager installs a LaunchAgent on macOS
+type launchdManager struct{}
+
+func (launchdManager) enable(e *EnableCommand) error {
+	return e.enableMacOS()
+}
+
+func (launchdManager) check(e *EnableCommand, report *driftReport) error {
+	return e.checkMacOS(report)
+}
+
+func (launchdManager) render(r *RenderCommand, exePath string, orgs map[string][]string, orgNames []string) error {
+	return r.renderMacOS(exePath, orgs, orgNames)
+}
+
+func (launchdManager) disable(d *DisableCommand) error {
+	return d.disableMacOS()
+}
+
+func (launchdManager) stop(s *StopCommand) error {
+	return s.stopMacOS()
+}
+
+// systemdManager installs systemd services, per org or per runner
+type systemdManager struct{}
+
+func (systemdManager) enable(e *EnableCommand) error {
+	return e.enableLinux()
+}
+
+func (systemdManager) check(e *EnableCommand, report *driftReport) error {
+	return e.checkLinux(report)
+}
+
+func (systemdManager) render(r *RenderCommand, exePath string, orgs map[string][]string, orgNames []string) error {
+	return r.renderSystemd(exePath, orgs, orgNames)
+}
+
+func (systemdManager) disable(d *DisableCommand) error {
+	return d.disableLinux()
+}
+
+func (systemdManager) stop(s *StopCommand) error {
+	return s.stopLinux()
+}
@@ -11,6 +11,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 type SetupCommand struct {
@@ -48,6 +49,7 @@ func (s *SetupCommand) Run() error {
 		return fmt.Errorf("failed to download runner: %w", err)
 	}
 	fmt.Printf("Runner downloaded to: %s\n", runnerPath)
+	version := runnerArchiveVersion(runnerPath)
 
 	// Step 2: Setup runners for each org
 	for _, org := range s.Orgs {
@@ -87,6 +89,14 @@ func (s *SetupCommand) Run() error {
 				return fmt.Errorf("failed to configure runner %s: %w", runnerName, err)
 			}
 
+			err = updateManifest(s.RootDir, func(m *Manifest) error {
+				m.addRunner(s.RootDir, runnerDir, version, s.AdditionalLabels)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
 			fmt.Printf("  Runner %s configured successfully\n", runnerName)
 		}
 	}
@@ -209,6 +219,16 @@ func (s *SetupCommand) downloadRunner() (string, error) {
 	return destPath, nil
 }
 
+// runnerArchiveVersion returns the runner version of a release archive such
+// as actions-runner-linux-x64-2.311.0.tar.gz
+func runnerArchiveVersion(archivePath string) string {
+	name := strings.TrimSuffix(filepath.Base(archivePath), ".tar.gz")
+	if i := strings.LastIndex(name, "-"); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
 func (s *SetupCommand) getRegistrationToken(org string) (string, error) {
 	url := fmt.Sprintf("https://api.github.com/orgs/%s/actions/runners/registration-token", org)
 	req, err := http.NewRequest("POST", url, nil)
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a flag value for sizes such as 512M or 10G (powers of 1024)
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.ToUpper(strings.TrimSpace(string(text)))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+
+	multiplier := ByteSize(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.size
+			s = strings.TrimSuffix(s, unit.suffix)
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return fmt.Errorf("invalid size: %s", text)
+	}
+	*b = ByteSize(value * float64(multiplier))
+	return nil
+}
+
+func (b ByteSize) String() string {
+	for _, unit := range byteSizeUnits {
+		if b >= unit.size && b%unit.size == 0 {
+			return fmt.Sprintf("%d%s", b/unit.size, strings.TrimSuffix(unit.suffix, "B"))
+		}
+	}
+	return fmt.Sprintf("%d", int64(b))
+}
+
+// Human formats the size with one decimal in the largest fitting unit, for logs
+func (b ByteSize) Human() string {
+	for _, unit := range byteSizeUnits {
+		if b >= unit.size && unit.size > 1 {
+			return fmt.Sprintf("%.1f%s", float64(b)/float64(unit.size), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
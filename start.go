@@ -2,29 +2,139 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"runtime"
-	"sync"
 	"syscall"
 	"time"
 )
 
+// runnerKillGrace is how long a runner gets to exit after SIGINT before it is killed
+const runnerKillGrace = 10 * time.Second
+
+// runnerRetryDelay is how long a runner waits before retrying after it failed to start
+const runnerRetryDelay = 30 * time.Second
+
+// jobTimeoutCheckInterval is how often the job timeout watchdog checks running jobs
+const jobTimeoutCheckInterval = 5 * time.Second
+
+// Exit reasons reported to post-run hooks, quarantine and ctl status
+const (
+	exitReasonOOMKill = "oom-kill"
+	exitReasonTimeout = "timeout"
+)
+
+// errJobTimeout is returned by waitRunner when the job timeout watchdog terminated the runner
+var errJobTimeout = errors.New("job timed out")
+
 type StartCommand struct {
-	RootDir string `name:"root-dir" type:"path" help:"Root directory" env:"ROOT_RUNNERS_DIR" default:"~/.github-runners"`
+	RootDir        string        `name:"root-dir" type:"path" help:"Root directory" env:"ROOT_RUNNERS_DIR" default:"~/.github-runners"`
+	StopTimeout    time.Duration `name:"stop-timeout" help:"How long to wait for busy runners to finish their current job on shutdown (0 waits forever)" env:"GHRUNNER_STOP_TIMEOUT" default:"30s"`
+	ReloadInterval time.Duration `name:"reload-interval" help:"How often to reload the runners recorded in the manifest, rescanning the root directory when it can write the manifest (0 only reloads on SIGHUP)" env:"GHRUNNER_RELOAD_INTERVAL" default:"0s"`
+	Runners        []string      `name:"runner" help:"Only run this runner, as <org>/<name> relative to the root directory or as its escaped systemd instance name (repeatable)" env:"GHRUNNER_RUNNER"`
+	Only           []string      `name:"only" help:"Only run runners whose <org>/<name> matches one of these globs, e.g. org1/*" env:"GHRUNNER_ONLY"`
+	Exclude        []string      `name:"exclude" help:"Don't run runners whose <org>/<name> matches one of these globs" env:"GHRUNNER_EXCLUDE"`
+	ControlSocket  bool          `name:"control-socket" negatable:"" help:"Listen on a control socket for ghrunner ctl" default:"true"`
+	Socket         string        `name:"socket" type:"path" help:"Control socket path (defaults to ghrunner.sock in the root directory, where ghrunner ctl looks for it)" env:"GHRUNNER_SOCKET"`
+
+	PreRunHook       string        `name:"pre-run-hook" help:"Shell command to run in the runner directory before each runner start" env:"GHRUNNER_PRE_RUN_HOOK"`
+	PostRunHook      string        `name:"post-run-hook" help:"Shell command to run in the runner directory after each runner exit, before _work is removed" env:"GHRUNNER_POST_RUN_HOOK"`
+	HookTimeout      time.Duration `name:"hook-timeout" help:"Maximum duration of a pre-run or post-run hook (0 for no limit)" env:"GHRUNNER_HOOK_TIMEOUT" default:"5m"`
+	JobStartedHook   string        `name:"job-started-hook" type:"path" help:"Script the runner executes before every job (ACTIONS_RUNNER_HOOK_JOB_STARTED)" env:"GHRUNNER_JOB_STARTED_HOOK"`
+	JobCompletedHook string        `name:"job-completed-hook" type:"path" help:"Script the runner executes after every job (ACTIONS_RUNNER_HOOK_JOB_COMPLETED)" env:"GHRUNNER_JOB_COMPLETED_HOOK"`
+
+	QuarantineDir     string   `name:"quarantine-dir" type:"path" help:"Where _work of failed runs is kept (defaults to _quarantine in the root directory)" env:"GHRUNNER_QUARANTINE_DIR"`
+	QuarantineKeep    int      `name:"quarantine-keep" help:"Number of failed workspaces to keep (0 disables quarantine)" env:"GHRUNNER_QUARANTINE_KEEP" default:"3"`
+	QuarantineMaxSize ByteSize `name:"quarantine-max-size" help:"Maximum total size of kept workspaces (0 for no limit)" env:"GHRUNNER_QUARANTINE_MAX_SIZE" default:"5G"`
+
+	MinFreeSpace      ByteSize      `name:"min-free-space" help:"Hold runners from taking new jobs while the root dir or --disk-paths have less free space than this (0 disables the watchdog)" env:"GHRUNNER_MIN_FREE_SPACE" default:"0"`
+	DiskPaths         []string      `name:"disk-paths" sep:"," help:"Additional paths to watch for free space, e.g. /var/lib/docker" env:"GHRUNNER_DISK_PATHS"`
+	DiskCheckInterval time.Duration `name:"disk-check-interval" help:"How often the disk watchdog checks free space" env:"GHRUNNER_DISK_CHECK_INTERVAL" default:"1m"`
+	DiskCleanup       string        `name:"disk-cleanup" help:"Shell command to run when free space is low, e.g. docker system prune -af" env:"GHRUNNER_DISK_CLEANUP"`
+
+	CPUWeight int      `name:"cpu-weight" help:"CPU weight of each runner, 1-10000 (cgroup v2 on Linux, or container)" env:"GHRUNNER_CPU_WEIGHT"`
+	CPUQuota  int      `name:"cpu-quota" help:"CPU quota of each runner in percent of one CPU (cgroup v2 on Linux, or container)" env:"GHRUNNER_CPU_QUOTA"`
+	MemoryMax ByteSize `name:"memory-max" help:"Memory limit of each runner (cgroup v2 on Linux, or container)" env:"GHRUNNER_MEMORY_MAX" default:"0"`
+	PidsMax   int      `name:"pids-max" help:"Maximum number of processes of each runner (cgroup v2 on Linux, or container)" env:"GHRUNNER_PIDS_MAX"`
+
+	PoolMin         int           `name:"pool-min" help:"Number of idle runners to keep listening in pool mode" env:"GHRUNNER_POOL_MIN" default:"1"`
+	PoolMax         int           `name:"pool-max" help:"Maximum number of active runners, enabling pool mode where runners are started on demand (0 runs every runner)" env:"GHRUNNER_POOL_MAX"`
+	PoolIdleTimeout time.Duration `name:"pool-idle-timeout" help:"How long extra runners stay idle in pool mode before they are stopped" env:"GHRUNNER_POOL_IDLE_TIMEOUT" default:"10m"`
+
+	WebhookListen string   `name:"webhook-listen" help:"Address to receive GitHub workflow_job webhooks on, e.g. :8080, to start runners in pool mode as soon as jobs are queued" env:"GHRUNNER_WEBHOOK_LISTEN"`
+	WebhookSecret string   `name:"webhook-secret" help:"Secret the webhook payloads are signed with" env:"GHRUNNER_WEBHOOK_SECRET"`
+	WebhookLabels []string `name:"webhook-labels" sep:"," help:"Labels of the runners, jobs requesting other labels are ignored" env:"GHRUNNER_WEBHOOK_LABELS" default:"self-hosted"`
+
+	MaintenanceWindows    []string      `name:"maintenance-window" sep:";" help:"Recurring maintenance window as a cron expression in local time followed by its duration, e.g. \"0 3 * * sun 2h\" (repeatable)" env:"GHRUNNER_MAINTENANCE_WINDOWS"`
+	MaintenanceDrainAhead time.Duration `name:"maintenance-drain-ahead" help:"How long before a maintenance window runners stop taking new jobs" env:"GHRUNNER_MAINTENANCE_DRAIN_AHEAD" default:"30m"`
+	MaintenanceCommand    string        `name:"maintenance-command" help:"Shell command to run in a maintenance window once all runners stopped, as the user running start, e.g. docker system prune -af" env:"GHRUNNER_MAINTENANCE_COMMAND"`
+
+	JobTimeout time.Duration `name:"job-timeout" help:"Maximum duration of a job, after which the runner is terminated and restarted (0 for no limit)" env:"GHRUNNER_JOB_TIMEOUT" default:"0s"`
+
+	RunAsOwner bool `name:"run-as-owner" help:"When running as root, run each runner as the owner of its directory" env:"GHRUNNER_RUN_AS_OWNER"`
+
+	ContainerImage   string   `name:"container-image" help:"Run each runner iteration in a fresh container from this image" env:"GHRUNNER_CONTAINER_IMAGE"`
+	ContainerRuntime string   `name:"container-runtime" help:"Container runtime binary (docker or podman)" env:"GHRUNNER_CONTAINER_RUNTIME" default:"docker"`
+	ContainerArgs    []string `name:"container-args" sep:"none" help:"Extra argument for the container run command (repeatable), e.g. --container-args=--network=host" env:"GHRUNNER_CONTAINER_ARGS"`
+
+	EnvFiles   []string `name:"env-file" help:"Dotenv files merged into every runner's environment, before ghrunner.env files found in the root, org and runner directories" env:"GHRUNNER_ENV_FILES"`
+	SecretsDir string   `name:"secrets-dir" type:"path" help:"Directory of secret files, e.g. /run/secrets, each exported to runners as a variable named after the file and masked in logs" env:"GHRUNNER_SECRETS_DIR"`
+
+	Launch     string `name:"launch" enum:"shell,direct" help:"Start run.sh through a login shell or exec it directly with a minimal environment (shell, direct)" env:"GHRUNNER_LAUNCH" default:"shell"`
+	Shell      string `name:"shell" help:"Shell used in shell launch mode (default /bin/zsh on macOS, /bin/bash elsewhere)" env:"GHRUNNER_SHELL"`
+	ShellFlags string `name:"shell-flags" help:"Flags passed to the shell before the command (default -lic on macOS, -lc elsewhere)" env:"GHRUNNER_SHELL_FLAGS"`
+
+	cgroups *cgroupManager
+	masker  secretMasker
+}
+
+// quarantinePolicy returns where the failed workspaces of a runner are
+// kept. With --run-as-owner each runner keeps its own in its directory,
+// accessible only to its user, so runners can't read each other's.
+func (s *StartCommand) quarantinePolicy(runnerDir string) (quarantinePolicy, error) {
+	p := quarantinePolicy{Dir: s.QuarantineDir, Keep: s.QuarantineKeep, MaxSize: s.QuarantineMaxSize, Private: s.RunAsOwner}
+	if s.RunAsOwner && p.Dir == "" {
+		cred, _, err := runnerCredential(runnerDir)
+		if err != nil {
+			return p, err
+		}
+		p.Dir = filepath.Join(runnerDir, quarantineDirName)
+		p.Owner = cred
+	}
+	if p.Dir == "" {
+		p.Dir = filepath.Join(s.RootDir, quarantineDirName)
+	}
+	return p, nil
 }
 
 func (s *StartCommand) Run() error {
-	runnerDirs, err := searchRunnerDirs(s.RootDir)
+	if err := s.validatePool(); err != nil {
+		return err
+	}
+	if s.WebhookListen != "" && s.WebhookSecret == "" {
+		return fmt.Errorf("--webhook-listen requires --webhook-secret")
+	}
+	windows, err := s.maintenanceWindows()
+	if err != nil {
+		return err
+	}
+
+	runnerDirs, err := manifestRunners(s.RootDir)
 	if err != nil {
-		return fmt.Errorf("failed to search runner dirs: %w", err)
+		return err
+	}
+	if err := s.checkRunnerFilters(runnerDirs); err != nil {
+		return err
 	}
+	runnerDirs = s.filterRunners(runnerDirs)
 
 	fmt.Printf("Found %d runners\n", len(runnerDirs))
-	if len(runnerDirs) == 0 {
+	if len(runnerDirs) == 0 && s.ReloadInterval <= 0 {
 		fmt.Println("No runners found, exiting")
 		return nil
 	}
@@ -32,6 +142,15 @@ func (s *StartCommand) Run() error {
 		fmt.Printf("  - %s\n", dir)
 	}
 
+	// Containers get their limits from the container runtime instead
+	if limits := s.cgroupLimits(); limits.enabled() && s.ContainerImage == "" {
+		s.cgroups, err = setupCgroups(limits)
+		if err != nil {
+			return fmt.Errorf("failed to set up cgroups: %w", err)
+		}
+		fmt.Printf("Runner resource limits: %s\n", limits)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -39,17 +158,75 @@ func (s *StartCommand) Run() error {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	var wg sync.WaitGroup
-	for _, dir := range runnerDirs {
-		wg.Add(1)
-		go func(dir string) {
-			defer wg.Done()
-			s.runRunnerLoop(ctx, dir)
-		}(dir)
+	// Capture reload signal (SIGHUP)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
+	// Optionally poll the root directory for added or removed runners
+	var reloadTick <-chan time.Time
+	if s.ReloadInterval > 0 {
+		ticker := time.NewTicker(s.ReloadInterval)
+		defer ticker.Stop()
+		reloadTick = ticker.C
 	}
 
-	// Wait for shutdown signal
-	<-sigCh
+	// Listen before any runner or hook starts, see listenControl
+	var controlLn net.Listener
+	if s.ControlSocket {
+		socketPath := s.Socket
+		if socketPath == "" {
+			socketPath = controlSocketPath(s.RootDir)
+		}
+		controlLn, err = listenControl(socketPath)
+		if err != nil {
+			return fmt.Errorf("failed to listen on control socket: %w", err)
+		}
+		defer controlLn.Close()
+		fmt.Printf("Control socket: %s\n", socketPath)
+	}
+
+	sv := newSupervisor(ctx, s)
+	sv.sync(runnerDirs)
+	if controlLn != nil {
+		go sv.serveControl(controlLn)
+	}
+
+	if s.poolEnabled() {
+		fmt.Printf("Pool mode: keeping %d idle runners, up to %d active\n", s.PoolMin, s.PoolMax)
+		go sv.runPool(ctx)
+	}
+
+	if s.MinFreeSpace > 0 {
+		go s.watchDisk(ctx, sv)
+	}
+
+	if len(windows) > 0 {
+		go s.runMaintenance(ctx, sv, windows)
+	}
+
+	if s.WebhookListen != "" {
+		srv, err := sv.listenWebhook(s.WebhookListen)
+		if err != nil {
+			cancel()
+			sv.wait()
+			return fmt.Errorf("failed to listen for webhooks: %w", err)
+		}
+		defer srv.Close()
+		fmt.Printf("Webhook listener: %s\n", s.WebhookListen)
+	}
+
+	// Wait for shutdown signal, reloading the runner set in the meantime
+	for waiting := true; waiting; {
+		select {
+		case <-sigCh:
+			waiting = false
+		case <-hupCh:
+			fmt.Println("Reload signal received, rescanning runners...")
+			s.reload(sv, true)
+		case <-reloadTick:
+			s.reload(sv, false)
+		}
+	}
 	fmt.Println("\nShutdown signal received, gracefully stopping all runners...")
 	fmt.Println("(Press Ctrl+C again to force quit)")
 	cancel()
@@ -61,12 +238,49 @@ func (s *StartCommand) Run() error {
 		os.Exit(1)
 	}()
 
-	wg.Wait()
+	sv.wait()
 	fmt.Println("All runners stopped")
 	return nil
 }
 
-func (s *StartCommand) runRunnerLoop(ctx context.Context, dir string) {
+// reload rescans the root directory into the manifest, so runners set up by
+// hand or copied in are recorded, then applies the runners of the manifest
+// to the supervisor like startup does. Services running as a non-root user
+// can't write the manifest and only pick up what setup or enable recorded.
+func (s *StartCommand) reload(sv *supervisor, verbose bool) {
+	if rootDir, m, err := manifestRoot(s.RootDir); err == nil && m != nil {
+		if _, err := scanRunners(rootDir); err != nil && (verbose || !errors.Is(err, fs.ErrPermission)) {
+			fmt.Printf("Warning: failed to record runners in the manifest: %v\n", err)
+		}
+	}
+	runnerDirs, err := manifestRunners(s.RootDir)
+	if err != nil {
+		fmt.Printf("Failed to load runners: %v\n", err)
+		return
+	}
+	runnerDirs = s.filterRunners(runnerDirs)
+
+	added, removed := sv.sync(runnerDirs)
+	for _, dir := range added {
+		fmt.Printf("Runner added: %s\n", dir)
+	}
+	for _, dir := range removed {
+		fmt.Printf("Runner removed, draining: %s\n", dir)
+	}
+	if verbose || len(added) > 0 || len(removed) > 0 {
+		fmt.Printf("Reloaded runners: %d added, %d removed\n", len(added), len(removed))
+	}
+}
+
+func (s *StartCommand) runRunnerLoop(ctx context.Context, r *runner) {
+	dir := r.dir
+	var cgroup *runnerCgroup
+	defer func() {
+		if cgroup != nil {
+			cgroup.remove()
+		}
+	}()
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -74,32 +288,112 @@ func (s *StartCommand) runRunnerLoop(ctx context.Context, dir string) {
 		default:
 		}
 
+		// Hold the runner while it is paused from the control socket
+		if !r.waitResumed(ctx) {
+			return
+		}
+
 		// Clean up work directory before each run
 		workDir := filepath.Join(dir, "_work")
 		os.RemoveAll(workDir)
 
-		// Use shell to load user's environment variables
-		// macOS: /bin/zsh -lic
-		// Linux: /bin/bash -lc
+		// Merge the configured environment, re-read every run so edits apply to the next job
+		env, secrets, err := s.runnerEnv(dir)
+		var launch launchOptions
+		if err == nil {
+			// Takes the per-runner launch settings out, they aren't meant for jobs
+			launch, err = s.launchOptions(env)
+		}
+		if err != nil {
+			fmt.Printf("Runner %s %v, retrying in %s\n", dir, err, runnerRetryDelay)
+			sleepContext(ctx, runnerRetryDelay)
+			continue
+		}
+		s.masker.add(secrets)
+
+		// The runner loads .env into every job, along with its own job hooks
+		dotEnv := s.jobHookEnv()
+		for key, value := range env {
+			dotEnv[key] = value
+		}
+		if err := updateDotEnv(filepath.Join(dir, ".env"), dotEnv, len(secrets) > 0); err != nil {
+			// Starting without the configured variables could leak into jobs unnoticed
+			fmt.Printf("Runner %s failed to update .env: %v, retrying in %s\n", dir, err, runnerRetryDelay)
+			sleepContext(ctx, runnerRetryDelay)
+			continue
+		}
+
+		if err := s.runHook(ctx, "pre-run", s.PreRunHook, r); err != nil {
+			fmt.Printf("Runner %s %v, retrying in %s\n", dir, err, runnerRetryDelay)
+			sleepContext(ctx, runnerRetryDelay)
+			continue
+		}
+
 		var cmd *exec.Cmd
-		runScript := fmt.Sprintf("cd %s && exec ./run.sh --once", dir)
-		if runtime.GOOS == "darwin" {
-			cmd = exec.Command("/bin/zsh", "-lic", runScript)
+		baseEnv := os.Environ()
+		if s.ContainerImage != "" {
+			// Fresh container per iteration, removing any left over by a crash first
+			s.removeContainer(dir)
+			cmd = s.containerCommand(dir, env)
 		} else {
-			cmd = exec.Command("/bin/bash", "-lc", runScript)
+			cmd, baseEnv = launch.hostCommand(dir)
 		}
 		cmd.Dir = dir
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Env = append(baseEnv, envList(env)...)
+		// Watch the runner output to know whether it is running a job
+		stdout := &lineWriter{w: os.Stdout, fn: r.observe, masker: &s.masker}
+		stderr := &lineWriter{w: os.Stderr, masker: &s.masker}
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
 		// Run child process in its own process group so Ctrl+C doesn't kill it directly
 		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
+		// Drop privileges to the runner's own user
+		if s.RunAsOwner && s.ContainerImage == "" {
+			cred, env, err := runnerCredential(dir)
+			if err != nil {
+				fmt.Printf("Runner %s failed to determine its user: %v, retrying in %s\n", dir, err, runnerRetryDelay)
+				sleepContext(ctx, runnerRetryDelay)
+				continue
+			}
+			if cred != nil {
+				cmd.SysProcAttr.Credential = cred
+				cmd.Env = append(cmd.Env, env...)
+			}
+		}
+
+		// Start the process group directly inside the runner's cgroup
+		closeCgroup := func() {}
+		if s.cgroups != nil {
+			var err error
+			if cgroup == nil {
+				cgroup, err = s.cgroups.forRunner(dir)
+			}
+			if err == nil {
+				closeCgroup, err = cgroup.apply(cmd)
+			}
+			if err != nil {
+				fmt.Printf("Runner %s failed to set up cgroup: %v, retrying in %s\n", dir, err, runnerRetryDelay)
+				sleepContext(ctx, runnerRetryDelay)
+				continue
+			}
+		}
+
 		fmt.Printf("Starting runner: %s\n", dir)
+		r.resetJob()
 
-		if err := cmd.Start(); err != nil {
-			fmt.Printf("Runner %s failed to start: %v\n", dir, err)
+		err = cmd.Start()
+		closeCgroup()
+		if err != nil {
+			fmt.Printf("Runner %s failed to start: %v, retrying in %s\n", dir, err, runnerRetryDelay)
+			sleepContext(ctx, runnerRetryDelay)
 			continue
 		}
+		r.setRunning(true)
+		if r.holds.String() != "" {
+			// A hold was set while the runner was starting
+			r.request(actionHold)
+		}
 
 		// Wait for either process to finish or context to be cancelled
 		done := make(chan error, 1)
@@ -107,37 +401,179 @@ func (s *StartCommand) runRunnerLoop(ctx context.Context, dir string) {
 			done <- cmd.Wait()
 		}()
 
+		action, err := s.waitRunner(ctx, r, cmd, done)
+		r.setRunning(false)
+		stdout.Flush()
+		stderr.Flush()
+
+		exitCode := cmd.ProcessState.ExitCode()
+		exitReason := ""
+		if errors.Is(err, errJobTimeout) {
+			exitReason = exitReasonTimeout
+		}
+		if (cgroup != nil && cgroup.oomKilled()) || (s.ContainerImage != "" && s.containerOOMKilled(dir)) {
+			exitReason = exitReasonOOMKill
+			fmt.Printf("Runner %s was killed by the OOM killer (memory max %s)\n", dir, s.MemoryMax.Human())
+		}
+		if s.ContainerImage != "" {
+			// Destroy the container even if the runtime client was killed
+			s.removeContainer(dir)
+		}
+
+		r.recordExit(err, exitReason)
+
+		// The post-run hook still runs while shutting down so it can collect
+		// artifacts, limited to shutdownHookTimeout then
+		hookEnv := []string{fmt.Sprintf("GHRUNNER_EXIT_CODE=%d", exitCode), "GHRUNNER_EXIT_REASON=" + exitReason}
+		hookCtx, cancelHook := context.WithoutCancel(ctx), context.CancelFunc(func() {})
+		if ctx.Err() != nil {
+			hookCtx, cancelHook = context.WithTimeout(hookCtx, shutdownHookTimeout)
+		}
+		if err := s.runHook(hookCtx, "post-run", s.PostRunHook, r, hookEnv...); err != nil {
+			fmt.Printf("Runner %s %v\n", dir, err)
+		}
+		cancelHook()
+
+		// Keep the workspace of a failed run for post-mortem debugging
+		if err != nil || exitReason != "" {
+			policy, qerr := s.quarantinePolicy(dir)
+			path := ""
+			if qerr == nil {
+				path, qerr = policy.quarantineWorkDir(dir, workDir, exitCode, exitReason)
+			}
+			if qerr != nil {
+				fmt.Printf("Runner %s failed to quarantine work directory: %v\n", dir, qerr)
+			}
+			if path != "" {
+				fmt.Printf("Runner %s work directory quarantined: %s\n", dir, path)
+			}
+		}
+
+		// Clean up work directory after each run
+		os.RemoveAll(workDir)
+
+		if ctx.Err() != nil {
+			fmt.Printf("Runner stopped: %s\n", dir)
+			return
+		}
+		if action != actionNone {
+			fmt.Printf("Runner %s %s complete\n", dir, action)
+			continue
+		}
+
+		if err != nil {
+			fmt.Printf("Runner %s error: %v\n", dir, err)
+		}
+
+		fmt.Printf("Runner %s completed, restarting...\n", dir)
+	}
+}
+
+// waitRunner waits for the runner process to exit, stopping it when ctx is
+// cancelled or a control action asks for it
+func (s *StartCommand) waitRunner(ctx context.Context, r *runner, cmd *exec.Cmd, done <-chan error) (runnerAction, error) {
+	// Check the job duration periodically, a nil channel disables the watchdog
+	var jobCheck <-chan time.Time
+	if s.JobTimeout > 0 {
+		ticker := time.NewTicker(jobTimeoutCheckInterval)
+		defer ticker.Stop()
+		jobCheck = ticker.C
+	}
+
+	for {
 		select {
+		case <-jobCheck:
+			started := r.jobStarted()
+			if started.IsZero() || time.Since(started) < s.JobTimeout {
+				continue
+			}
+			fmt.Printf("Runner %s job exceeded the job timeout of %s, terminating...\n", r.dir, s.JobTimeout)
+			terminateRunner(r, cmd, done)
+			return actionNone, errJobTimeout
 		case <-ctx.Done():
 			// Context cancelled, gracefully stop the runner
-			if cmd.Process != nil {
-				fmt.Printf("Stopping runner: %s (waiting for current job to finish...)\n", dir)
-				// Send SIGINT first for graceful shutdown
-				syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
-
-				// Wait for process to exit with timeout
-				select {
-				case <-done:
-					// Process exited gracefully
-				case <-time.After(30 * time.Second):
-					// Timeout, force kill
-					fmt.Printf("Runner %s didn't stop in time, force killing...\n", dir)
-					syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
-					<-done
-				}
+			s.stopRunner(r, cmd, done, s.StopTimeout)
+			return actionNone, nil
+		case <-r.wake:
+			action := r.takeAction()
+			switch action {
+			case actionPause:
+				// Take the runner out of rotation now, even mid-job
+				s.stopRunner(r, cmd, done, -1)
+			case actionDrain, actionHold, actionRetire:
+				// Let the current job finish, however long it takes
+				s.stopRunner(r, cmd, done, 0)
+			case actionRestart:
+				s.stopRunner(r, cmd, done, s.StopTimeout)
+			default:
+				// Superseded request, keep waiting for the process
+				continue
 			}
-			os.RemoveAll(workDir)
-			fmt.Printf("Runner stopped: %s\n", dir)
-			return
+			return action, nil
 		case err := <-done:
-			// Clean up work directory after each run
-			os.RemoveAll(workDir)
+			return actionNone, err
+		}
+	}
+}
 
-			if err != nil {
-				fmt.Printf("Runner %s error: %v\n", dir, err)
-			}
+// stopRunner stops an idle runner right away and lets a busy runner finish its
+// job for up to timeout before interrupting it. A zero timeout waits forever
+// and a negative one interrupts the job immediately.
+func (s *StartCommand) stopRunner(r *runner, cmd *exec.Cmd, done <-chan error, timeout time.Duration) {
+	if r.busy.Load() && timeout >= 0 {
+		fmt.Printf("Stopping runner: %s (waiting for current job to finish...)\n", r.dir)
+
+		// A nil channel never fires, so a zero timeout waits forever
+		var expired <-chan time.Time
+		if timeout > 0 {
+			expired = time.After(timeout)
+		}
+
+		select {
+		case <-done:
+			// Job finished and run.sh --once exited on its own
+			return
+		case <-expired:
+			fmt.Printf("Runner %s job didn't finish within %s, interrupting...\n", r.dir, timeout)
+		}
+	} else {
+		fmt.Printf("Stopping idle runner: %s\n", r.dir)
+	}
 
-			fmt.Printf("Runner %s completed, restarting...\n", dir)
+	terminateRunner(r, cmd, done)
+}
+
+// terminateRunner signals the runner process group with SIGINT, then SIGTERM
+// and finally SIGKILL, giving it runnerKillGrace to exit after each signal
+func terminateRunner(r *runner, cmd *exec.Cmd, done <-chan error) {
+	signals := []struct {
+		sig  syscall.Signal
+		name string
+	}{
+		{syscall.SIGINT, "SIGINT"},
+		{syscall.SIGTERM, "SIGTERM"},
+	}
+	for _, s := range signals {
+		syscall.Kill(-cmd.Process.Pid, s.sig)
+		select {
+		case <-done:
+			// Process exited gracefully
+			return
+		case <-time.After(runnerKillGrace):
+			fmt.Printf("Runner %s didn't stop after %s...\n", r.dir, s.name)
 		}
 	}
+
+	// Timeout, force kill
+	fmt.Printf("Runner %s force killing...\n", r.dir)
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	<-done
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
 }
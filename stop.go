@@ -4,36 +4,28 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"os/user"
-	"path/filepath"
-	"runtime"
 	"strings"
 )
 
 type StopCommand struct {
 	RootDir string `name:"root-dir" type:"path" help:"Root directory" env:"ROOT_RUNNERS_DIR" default:"~/.github-runners"`
+	Init    string `name:"init" enum:"auto,launchd,systemd,openrc,runit,supervisord" help:"Service manager (auto, launchd, systemd, openrc, runit, supervisord), auto detects the running one" env:"GHRUNNER_INIT" default:"auto"`
+	User    bool   `name:"user" help:"Stop systemd user services of the current user instead of system services (Linux)" env:"GHRUNNER_SYSTEMD_USER"`
 }
 
 func (s *StopCommand) Run() error {
-	switch runtime.GOOS {
-	case "darwin":
-		return s.stopMacOS()
-	case "linux":
-		return s.stopLinux()
-	default:
-		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+	manager, err := newServiceManager(s.Init)
+	if err != nil {
+		return err
 	}
+	return manager.stop(s)
 }
 
 func (s *StopCommand) stopMacOS() error {
-	homeDir, err := os.UserHomeDir()
+	plistPath, err := launchAgentPath(s.RootDir)
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+		return err
 	}
-	launchAgentsDir := filepath.Join(homeDir, "Library", "LaunchAgents")
-
-	label := "com.github.actions.runner"
-	plistPath := filepath.Join(launchAgentsDir, label+".plist")
 
 	// Check if plist exists
 	if _, err := os.Stat(plistPath); os.IsNotExist(err) {
@@ -56,44 +48,29 @@ func (s *StopCommand) stopMacOS() error {
 }
 
 func (s *StopCommand) stopLinux() error {
-	// Check if running as root
-	currentUser, err := user.Current()
-	if err != nil {
-		return fmt.Errorf("failed to get current user: %w", err)
-	}
-	if currentUser.Uid != "0" {
-		return fmt.Errorf("stop command on Linux requires root privileges. Please run with sudo")
+	scope := systemdScope{user: s.User}
+	if err := scope.requireRoot("stop"); err != nil {
+		return err
 	}
 
-	runnerDirs, err := searchRunnerDirs(s.RootDir)
+	services, err := scope.installedServices(s.RootDir)
 	if err != nil {
-		return fmt.Errorf("failed to search runner dirs: %w", err)
-	}
-
-	// Find all unique orgs
-	orgs := make(map[string]bool)
-	for _, runnerDir := range runnerDirs {
-		relPath, err := filepath.Rel(s.RootDir, runnerDir)
-		if err != nil {
-			continue
-		}
-		parts := strings.Split(relPath, string(filepath.Separator))
-		if len(parts) >= 1 {
-			orgs[parts[0]] = true
-		}
+		return err
 	}
-
-	if len(orgs) == 0 {
-		fmt.Println("No runners found")
+	if len(services) == 0 {
+		fmt.Println("No services found")
 		return nil
 	}
 
 	stopped := 0
-	for org := range orgs {
-		serviceName := fmt.Sprintf("ghrunner-%s", org)
+	for _, serviceName := range services {
+		// The template unit itself doesn't run
+		if strings.HasSuffix(serviceName, "@") {
+			continue
+		}
 
 		// Stop the service
-		cmd := exec.Command("systemctl", "stop", serviceName)
+		cmd := scope.systemctl("stop", serviceName)
 		if err := cmd.Run(); err != nil {
 			// Might not be running, that's fine
 			continue
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// supervisor runs one loop per runner directory and keeps that set in sync
+// with the runners found under the root directory
+type supervisor struct {
+	start *StartCommand
+	ctx   context.Context
+	wg    sync.WaitGroup
+	holds *holds
+
+	// queue holds jobs reported by the webhook, scaleNow wakes the pool scaler
+	queue    *jobQueue
+	scaleNow chan struct{}
+
+	mu       sync.Mutex
+	runners  map[string]*runner
+	draining map[string]chan struct{}
+
+	// maintenance describes the maintenance window state for ctl status
+	maintenance string
+}
+
+func newSupervisor(ctx context.Context, start *StartCommand) *supervisor {
+	return &supervisor{
+		start:    start,
+		ctx:      ctx,
+		holds:    newHolds(),
+		queue:    newJobQueue(),
+		scaleNow: make(chan struct{}, 1),
+		runners:  make(map[string]*runner),
+		draining: make(map[string]chan struct{}),
+	}
+}
+
+// sync starts loops for new runner directories and drains runners whose
+// directory is gone, leaving unchanged runners alone
+func (sv *supervisor) sync(dirs []string) (added, removed []string) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	wanted := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		wanted[dir] = true
+		if _, ok := sv.runners[dir]; ok {
+			continue
+		}
+		if _, ok := sv.draining[dir]; ok {
+			// The old loop is still finishing its job, pick it up on a later reload
+			fmt.Printf("Runner %s is still draining, not restarting yet\n", dir)
+			continue
+		}
+		sv.launch(dir)
+		added = append(added, dir)
+	}
+
+	for dir, r := range sv.runners {
+		if wanted[dir] {
+			continue
+		}
+		delete(sv.runners, dir)
+		sv.draining[dir] = r.done
+		r.cancel()
+		removed = append(removed, dir)
+
+		go func(dir string, done chan struct{}) {
+			<-done
+			sv.mu.Lock()
+			delete(sv.draining, dir)
+			sv.mu.Unlock()
+		}(dir, r.done)
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// launch starts the loop for a runner directory; sv.mu must be held
+func (sv *supervisor) launch(dir string) {
+	ctx, cancel := context.WithCancel(sv.ctx)
+	r := newRunner(dir, sv.holds)
+	r.cancel = cancel
+	// In pool mode the scaler decides when a runner comes up
+	r.standby = sv.start.poolEnabled()
+	sv.runners[dir] = r
+
+	sv.wg.Add(1)
+	go func() {
+		defer sv.wg.Done()
+		defer close(r.done)
+		defer cancel()
+		sv.start.runRunnerLoop(ctx, r)
+	}()
+}
+
+// hold keeps every runner from taking new jobs until release is called with
+// the same key. Idle runners are stopped and busy ones stop after their job.
+func (sv *supervisor) hold(key, message string) {
+	if !sv.holds.set(key, message) {
+		return
+	}
+	fmt.Printf("Holding runners: %s\n", message)
+
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	for _, r := range sv.runners {
+		r.request(actionHold)
+	}
+}
+
+// release clears a hold set by hold
+func (sv *supervisor) release(key string) {
+	if sv.holds.clear(key) {
+		fmt.Printf("Released runner hold: %s\n", key)
+	}
+}
+
+// runnerDirs returns the directories of the current runners, sorted
+func (sv *supervisor) runnerDirs() []string {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	dirs := make([]string, 0, len(sv.runners))
+	for dir := range sv.runners {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// runningCount returns the number of runner processes still running,
+// including those of removed runners that are draining
+func (sv *supervisor) runningCount() int {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	count := len(sv.draining)
+	for _, r := range sv.runners {
+		r.mu.Lock()
+		if r.running {
+			count++
+		}
+		r.mu.Unlock()
+	}
+	return count
+}
+
+func (sv *supervisor) setMaintenance(state string) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.maintenance = state
+}
+
+// wait blocks until every runner loop, including draining ones, has returned
+func (sv *supervisor) wait() {
+	sv.wg.Wait()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// systemdScope selects between system services managed by root and user
+// services of the current user (systemctl --user)
+type systemdScope struct {
+	user bool
+}
+
+// requireRoot checks that a system scope command runs as root
+func (sc systemdScope) requireRoot(command string) error {
+	if sc.user {
+		return nil
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("failed to get current user: %w", err)
+	}
+	if currentUser.Uid != "0" {
+		return fmt.Errorf("%s command on Linux requires root privileges. Please run with sudo, or use --user for systemd user services", command)
+	}
+	return nil
+}
+
+// unitDir returns the directory service files are written to
+func (sc systemdScope) unitDir() (string, error) {
+	if !sc.user {
+		return "/etc/systemd/system", nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config directory: %w", err)
+	}
+	return filepath.Join(configDir, "systemd", "user"), nil
+}
+
+// manager names the scope's services in the manifest
+func (sc systemdScope) manager() string {
+	if sc.user {
+		return "systemd-user"
+	}
+	return "systemd"
+}
+
+// wantedBy returns the target services are installed into
+func (sc systemdScope) wantedBy() string {
+	if sc.user {
+		return "default.target"
+	}
+	return "multi-user.target"
+}
+
+// systemctl returns a systemctl command for the scope
+func (sc systemdScope) systemctl(args ...string) *exec.Cmd {
+	if sc.user {
+		args = append([]string{"--user"}, args...)
+	}
+	return exec.Command("systemctl", args...)
+}
+
+// command returns how users run systemctl for the scope, for hints
+func (sc systemdScope) command() string {
+	if sc.user {
+		return "systemctl --user"
+	}
+	return "sudo systemctl"
+}
+
+// checkLinger warns when user services would stop at logout because
+// lingering isn't enabled for the current user
+func (sc systemdScope) checkLinger() {
+	if !sc.user {
+		return
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return
+	}
+	out, err := exec.Command("loginctl", "show-user", currentUser.Username, "--property=Linger", "--value").Output()
+	if err == nil && strings.TrimSpace(string(out)) == "yes" {
+		return
+	}
+	fmt.Printf("\nWarning: lingering is not enabled for %s, the services only run while you are logged in.\n", currentUser.Username)
+	fmt.Printf("To keep them running after logout and start them at boot: loginctl enable-linger %s\n", currentUser.Username)
+}
+
+// hardening returns the sandboxing directives of a --hardening preset.
+// compatible only restricts what jobs practically never need, strict also
+// makes the file system read-only except for the writable paths and keeps
+// jobs from gaining privileges through sudo or setuid binaries.
+// User managers can't set up mount namespaces, so user services only get
+// NoNewPrivileges.
+func (sc systemdScope) hardening(preset string, writable []string) []string {
+	var directives []string
+	if sc.user {
+		if preset == "strict" {
+			directives = append(directives, "NoNewPrivileges=yes")
+		}
+		return directives
+	}
+
+	if preset == "compatible" || preset == "strict" {
+		directives = append(directives,
+			"ProtectKernelModules=yes",
+			"ProtectKernelLogs=yes",
+		)
+	}
+	if preset == "strict" {
+		directives = append(directives,
+			"NoNewPrivileges=yes",
+			"PrivateTmp=yes",
+			"ProtectSystem=strict",
+		)
+		var paths []string
+		for _, path := range writable {
+			if path == "" {
+				continue
+			}
+			if strings.ContainsAny(path, " \"\\%") {
+				path = systemdQuote(path)
+			}
+			paths = append(paths, path)
+		}
+		if len(paths) > 0 {
+			directives = append(directives, "ReadWritePaths="+strings.Join(paths, " "))
+		}
+	}
+	return directives
+}
+
+// systemdQuote quotes an Environment= assignment or a path, escaping
+// specifiers so the value is used literally
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "%", "%%")
+	return `"` + s + `"`
+}
+
+// systemdEscape escapes a path-like string for unit names like
+// systemd-escape: / becomes -, and - and other characters not allowed
+// in unit names become \xNN
+func systemdEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '/':
+			b.WriteByte('-')
+		case c == '.' && i == 0,
+			!(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == ':' || c == '_' || c == '.'):
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// installedServices returns the services enable recorded in the manifest
+// for the scope, with template instances before their template unit.
+// Root directories enabled before there was a manifest get the services
+// derived from their runner directories.
+func (sc systemdScope) installedServices(rootDir string) ([]string, error) {
+	m, err := loadManifest(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if m != nil {
+		for _, service := range m.services(sc.manager()) {
+			names = append(names, service.Name)
+		}
+		if len(names) > 0 {
+			return names, nil
+		}
+	}
+
+	runnerDirs, err := searchRunnerDirs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search runner dirs: %w", err)
+	}
+	for _, runnerDir := range runnerDirs {
+		org, _, _ := strings.Cut(runnerRelName(rootDir, runnerDir), "/")
+		if name := "ghrunner-" + org; !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	// Per-runner instances of the ghrunner@ template unit
+	unitDir, err := sc.unitDir()
+	if err != nil {
+		return nil, err
+	}
+	if pathExists(filepath.Join(unitDir, systemdRunnerUnit)) {
+		for _, runnerDir := range runnerDirs {
+			names = append(names, runnerServiceName(rootDir, runnerDir))
+		}
+		names = append(names, strings.TrimSuffix(systemdRunnerUnit, ".service"))
+	}
+	return names, nil
+}
+
+// staleInstances returns the enabled ghrunner@ instances that aren't in
+// instances, such as those of removed runners or named before instance
+// names were escaped
+func (sc systemdScope) staleInstances(unitDir string, instances []string) []string {
+	links, _ := filepath.Glob(filepath.Join(unitDir, sc.wantedBy()+".wants", "ghrunner@*.service"))
+	var stale []string
+	for _, link := range links {
+		if name := strings.TrimSuffix(filepath.Base(link), ".service"); !slices.Contains(instances, name) {
+			stale = append(stale, name)
+		}
+	}
+	return stale
+}
+
+// disableInstance stops and disables a ghrunner@ instance, which has no
+// unit file of its own
+func (sc systemdScope) disableInstance(serviceName string) {
+	if err := sc.systemctl("disable", "--now", serviceName).Run(); err != nil {
+		fmt.Printf("Warning: failed to disable %s: %v\n", serviceName, err)
+		return
+	}
+	fmt.Printf("Disabled systemd service: %s\n", serviceName)
+}
+
+// removeService stops, disables and deletes a service, ignoring errors
+// when it isn't installed
+func (sc systemdScope) removeService(unitDir, serviceName string) {
+	_ = sc.systemctl("disable", "--now", serviceName).Run()
+	servicePath := filepath.Join(unitDir, serviceName+".service")
+	if err := os.Remove(servicePath); err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Warning: failed to remove %s: %v\n", servicePath, err)
+		}
+		return
+	}
+	fmt.Printf("Removed systemd service: %s\n", serviceName)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxUsernameLength is the longest username systemd accepts without a
+// warning, one less than useradd's limit
+const maxUsernameLength = 31
+
+// validUsername matches the usernames useradd accepts by default
+var validUsername = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+
+// sanitizeUsername turns a name into a valid Linux username: lowercase
+// letters, digits, _ and -, starting with a letter or _ and at most 31
+// characters. Shortened names end with a hash of the name so they stay unique.
+func sanitizeUsername(name string) string {
+	username := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, name)
+	if username == "" || !validUsername.MatchString(username[:1]) {
+		username = "ghr-" + username
+	}
+	if len(username) > maxUsernameLength {
+		sum := sha256.Sum256([]byte(name))
+		username = username[:maxUsernameLength-7] + "-" + hex.EncodeToString(sum[:])[:6]
+	}
+	return username
+}
+
+// orgUsername returns the Linux user of an org: its --username override,
+// or the org name with --user-prefix made a valid username
+func (e *EnableCommand) orgUsername(org string) string {
+	if username, ok := e.Usernames[org]; ok {
+		return username
+	}
+	return sanitizeUsername(e.UserPrefix + org)
+}
+
+// orgUsernames returns the Linux user of each org, checking that the
+// overrides are valid and that no two orgs share a user
+func (e *EnableCommand) orgUsernames(orgNames []string) (map[string]string, error) {
+	for org, username := range e.Usernames {
+		if len(username) > maxUsernameLength || !validUsername.MatchString(username) {
+			return nil, fmt.Errorf("invalid username %q for org %s: use lowercase letters, digits, _ and -, starting with a letter, at most %d characters", username, org, maxUsernameLength)
+		}
+	}
+
+	usernames := make(map[string]string)
+	owners := make(map[string]string)
+	for _, org := range orgNames {
+		username := e.orgUsername(org)
+		if other, ok := owners[username]; ok {
+			return nil, fmt.Errorf("orgs %s and %s both map to user %s, set one with --username=%s=<user>", other, org, username, org)
+		}
+		owners[username] = org
+		usernames[org] = username
+	}
+	return usernames, nil
+}
+
+// runnerDirMode is the mode of runner directories with --isolation=runner,
+// only their own user can read them
+const runnerDirMode = 0700
+
+// runnerUsername returns the Linux user of a runner with --isolation=runner:
+// --user-prefix, ghr- by default, and <org>-<name> made a valid username,
+// e.g. ghr-myorg-hostname-1 for the runner myorg/hostname-1
+func (e *EnableCommand) runnerUsername(org, runnerDir string) string {
+	prefix := e.UserPrefix
+	if prefix == "" {
+		prefix = "ghr-"
+	}
+	return sanitizeUsername(prefix + org + "-" + filepath.Base(runnerDir))
+}
+
+// checkRunnerUsernames checks that no two runners share a user with
+// --isolation=runner, which sanitizing their names could cause
+func (e *EnableCommand) checkRunnerUsernames(orgs map[string][]string, orgNames []string) error {
+	if e.Isolation != "runner" {
+		return nil
+	}
+	owners := make(map[string]string)
+	for _, org := range orgNames {
+		for _, runnerDir := range orgs[org] {
+			username := e.runnerUsername(org, runnerDir)
+			name := runnerRelName(e.RootDir, runnerDir)
+			if other, ok := owners[username]; ok {
+				return fmt.Errorf("runners %s and %s both map to user %s, rename one of the runner directories", other, name, username)
+			}
+			owners[username] = name
+		}
+	}
+	return nil
+}
+
+// checkAdoptable refuses to hand runner directories to an existing user
+// ghrunner didn't create, unless it is a system account or --adopt-users
+// is given. root is never adopted.
+func (e *EnableCommand) checkAdoptable(u *user.User, created []string) error {
+	if u.Uid == "0" {
+		return fmt.Errorf("refusing to run runners as %s with UID 0", u.Username)
+	}
+	if slices.Contains(created, u.Username) || e.AdoptUsers {
+		return nil
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil || uid >= loginUIDMin() {
+		return fmt.Errorf("user %s already exists and is a regular account, choose another user with --username or --user-prefix, or use it anyway with --adopt-users", u.Username)
+	}
+	return nil
+}
+
+// loginUIDMin returns the first UID of regular accounts from /etc/login.defs,
+// system accounts are below it
+func loginUIDMin() int {
+	uidMin := 1000
+	f, err := os.Open("/etc/login.defs")
+	if err != nil {
+		return uidMin
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "UID_MIN" {
+			if n, err := strconv.Atoi(fields[1]); err == nil {
+				uidMin = n
+			}
+		}
+	}
+	return uidMin
+}
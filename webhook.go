@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookMaxBody limits the size of webhook payloads, workflow_job events are a few KB
+const webhookMaxBody = 1 << 20
+
+// queuedJobTTL is how long a queued job is remembered without further events.
+// GitHub cancels jobs that were queued for 24 hours.
+const queuedJobTTL = 24 * time.Hour
+
+// workflowJobEvent is the part of a GitHub workflow_job webhook payload ghrunner uses
+type workflowJobEvent struct {
+	Action      string `json:"action"`
+	WorkflowJob struct {
+		ID         int64     `json:"id"`
+		Name       string    `json:"name"`
+		Labels     []string  `json:"labels"`
+		RunnerName string    `json:"runner_name"`
+		CreatedAt  time.Time `json:"created_at"`
+		StartedAt  time.Time `json:"started_at"`
+	} `json:"workflow_job"`
+	Organization struct {
+		Login string `json:"login"`
+	} `json:"organization"`
+}
+
+// WebhookStatus summarises the workflow_job events received for ctl status
+type WebhookStatus struct {
+	Queued      int    `json:"queued"`
+	Started     int64  `json:"started"`
+	LastLatency string `json:"last_latency,omitempty"`
+	AvgLatency  string `json:"avg_latency,omitempty"`
+}
+
+// jobQueue tracks the matching jobs waiting for a runner and their queue latency
+type jobQueue struct {
+	mu           sync.Mutex
+	queued       map[int64]time.Time
+	started      int64
+	totalLatency time.Duration
+	lastLatency  time.Duration
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{queued: make(map[int64]time.Time)}
+}
+
+func (q *jobQueue) add(id int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queued[id] = time.Now()
+}
+
+// remove forgets a job, returning false if it wasn't queued
+func (q *jobQueue) remove(id int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.queued[id]
+	delete(q.queued, id)
+	return ok
+}
+
+func (q *jobQueue) recordStart(latency time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.started++
+	q.totalLatency += latency
+	q.lastLatency = latency
+}
+
+// pending returns the number of jobs still waiting for a runner
+func (q *jobQueue) pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, queuedAt := range q.queued {
+		if time.Since(queuedAt) > queuedJobTTL {
+			// Missed the event that ended it
+			delete(q.queued, id)
+		}
+	}
+	return len(q.queued)
+}
+
+func (q *jobQueue) status() *WebhookStatus {
+	pending := q.pending()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := &WebhookStatus{Queued: pending, Started: q.started}
+	if q.started > 0 {
+		st.LastLatency = q.lastLatency.Round(time.Second).String()
+		st.AvgLatency = (q.totalLatency / time.Duration(q.started)).Round(time.Second).String()
+	}
+	return st
+}
+
+// validWebhookSignature checks an X-Hub-Signature-256 header against the payload
+func validWebhookSignature(secret string, body []byte, signature string) bool {
+	hexSum, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	sum, err := hex.DecodeString(hexSum)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sum, mac.Sum(nil))
+}
+
+// matchesLabels reports whether our runners can take a job requesting labels.
+// Like GitHub, a runner must have every requested label.
+func (s *StartCommand) matchesLabels(labels []string) bool {
+	for _, label := range labels {
+		found := false
+		for _, ours := range s.WebhookLabels {
+			if strings.EqualFold(label, ours) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// listenWebhook starts the webhook listener, returning the server to close on shutdown
+func (sv *supervisor) listenWebhook(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{
+		Handler:           http.HandlerFunc(sv.handleWebhook),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Webhook listener error: %v\n", err)
+		}
+	}()
+	return srv, nil
+}
+
+func (sv *supervisor) handleWebhook(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, webhookMaxBody))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if !validWebhookSignature(sv.start.WebhookSecret, body, req.Header.Get("X-Hub-Signature-256")) {
+		fmt.Printf("Webhook: rejected request with invalid signature from %s\n", req.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	switch req.Header.Get("X-GitHub-Event") {
+	case "ping":
+		fmt.Fprintln(w, "pong")
+		return
+	case "workflow_job":
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var event workflowJobEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	sv.workflowJob(event)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// workflowJob updates the job queue from a workflow_job event and lets the
+// pool scale right away
+func (sv *supervisor) workflowJob(event workflowJobEvent) {
+	job := event.WorkflowJob
+	if !sv.start.matchesLabels(job.Labels) || !sv.hasOrg(event.Organization.Login) {
+		return
+	}
+
+	switch event.Action {
+	case "queued":
+		sv.queue.add(job.ID)
+		fmt.Printf("Webhook: job %d (%s) queued for labels %s\n", job.ID, job.Name, strings.Join(job.Labels, ","))
+	case "in_progress":
+		sv.queue.remove(job.ID)
+		if !job.CreatedAt.IsZero() && !job.StartedAt.IsZero() {
+			latency := job.StartedAt.Sub(job.CreatedAt)
+			sv.queue.recordStart(latency)
+			fmt.Printf("Webhook: job %d picked up by %s after %s in queue\n", job.ID, job.RunnerName, latency.Round(time.Second))
+		}
+	case "completed":
+		if sv.queue.remove(job.ID) {
+			// Cancelled before any runner took it
+			fmt.Printf("Webhook: job %d completed without starting\n", job.ID)
+		}
+	default:
+		return
+	}
+
+	select {
+	case sv.scaleNow <- struct{}{}:
+	default:
+	}
+}
+
+// hasOrg reports whether any supervised runner belongs to org. Events without
+// an organization, e.g. for user repositories, match every runner.
+func (sv *supervisor) hasOrg(org string) bool {
+	if org == "" {
+		return true
+	}
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	for dir := range sv.runners {
+		if strings.EqualFold(filepath.Base(filepath.Dir(dir)), org) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestValidWebhookSignature(t *testing.T) {
+	body := []byte(`{"action":"queued"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	valid := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name      string
+		secret    string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid", "secret", body, valid, true},
+		{"wrong secret", "other", body, valid, false},
+		{"modified body", "secret", []byte(`{"action":"completed"}`), valid, false},
+		{"missing prefix", "secret", body, valid[len("sha256="):], false},
+		{"sha1 prefix", "secret", body, "sha1=" + valid[len("sha256="):], false},
+		{"not hex", "secret", body, "sha256=zz", false},
+		{"truncated", "secret", body, valid[:len(valid)-2], false},
+		{"empty", "secret", body, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validWebhookSignature(tt.secret, tt.body, tt.signature); got != tt.want {
+				t.Errorf("validWebhookSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}